@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // FirestoreEvent is the payload of a Firestore event.
@@ -48,6 +51,7 @@ type FirestoreValue struct {
 var (
 	ctx             = context.Background()
 	firestoreClient *firestore.Client
+	clientOnce      sync.Once // client is made on first event (not in init, so tests don't need credentials)
 )
 
 func main() {
@@ -67,22 +71,69 @@ func init() {
 	// if err != nil {
 	// 	log.Fatalf("app.Firestore: %v", err)
 	// }
-	firestoreClient = initFirestoreDatabase(ctx)
 }
 
-// CloudCounter is triggered by a change to a Firestore document.
+// popularity windows (days, including today UTC) - same as in main app
+var windows = []struct {
+	days         int
+	count, plays string // counter field and map of plays in day document
+}{
+	{7, "count_7d", "in_7d"},
+	{30, "count_30d", "in_30d"},
+}
+
+// CloudCounter is triggered by creation of a play document
+// users/{user}/plays/{playedAtMs_trackID} and counts the play.
+// Functions may be called more than once for the same event so play
+// is marked as counted in the same transaction which counts it.
+// Listens are counted all time (count) and in windows (count_7d,
+// count_30d), plays in windows are also added to day document
+// users/{user}/popular_days/{YYYY-MM-DD} so MidnightRun knows
+// what to subtract when the day leaves window.
 func CloudCounter(ctx context.Context, e FirestoreEvent) error {
+	clientOnce.Do(func() { firestoreClient = initFirestoreDatabase(context.Background()) })
 	fullPath := strings.Split(e.Value.Name, "/documents/")[1]
 	pathParts := strings.Split(fullPath, "/")
 	userID := pathParts[1]
 	docID := pathParts[3]
+	if i := strings.Index(docID, "_"); i >= 0 {
+		docID = docID[i+1:] // track ID
+	}
 	// log.Printf("userID and docID: %s %s", userID, docID)
 	// In order to avoid triggering infinite loop we keep counters in separate collection
-	path := fmt.Sprintf("users/%s/popular_tracks", userID)
-	// log.Println(path)
-	docRef := firestoreClient.Collection(path).Doc(docID)
-	_, err := docRef.Set(ctx, map[string]interface{}{
-		"count": firestore.Increment(1)}, firestore.MergeAll)
+	playRef := firestoreClient.Doc(fullPath)
+	counterRef := firestoreClient.Collection(fmt.Sprintf("users/%s/popular_tracks", userID)).Doc(docID)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(playRef)
+		if status.Code(err) == codes.NotFound { // deleted in the meantime
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		counters, day, plays := countPlay(snap.Data(), time.Now())
+		if counters == nil {
+			return nil
+		}
+		fields := map[string]interface{}{}
+		for field, n := range counters {
+			fields[field] = firestore.Increment(n)
+		}
+		if len(plays) > 0 {
+			dayFields := map[string]interface{}{"day": day}
+			for _, field := range plays {
+				dayFields[field] = map[string]interface{}{docID: firestore.Increment(1)}
+			}
+			dayRef := firestoreClient.Collection(fmt.Sprintf("users/%s/popular_days", userID)).Doc(day)
+			if err := tx.Set(dayRef, dayFields, firestore.MergeAll); err != nil {
+				return err
+			}
+		}
+		if err := tx.Set(counterRef, fields, firestore.MergeAll); err != nil {
+			return err
+		}
+		return tx.Update(playRef, []firestore.Update{{Path: "counted", Value: true}})
+	})
 	// https://cloud.google.com/functions/docs/calling/cloud-firestore#specifying_the_document_path
 	// Functions only respond to document changes, and cannot monitor specific fields or collections.
 	if err != nil {
@@ -93,6 +144,39 @@ func CloudCounter(ctx context.Context, e FirestoreEvent) error {
 	return nil
 }
 
+// countPlay - counters play adds to (nil if it isn't counted: migrated
+// from legacy history or counted already), its day (UTC) and maps of
+// plays in day document it goes to
+func countPlay(play map[string]interface{}, now time.Time) (map[string]int, string, []string) {
+	// plays copied from legacy history have been counted already
+	if migrated, _ := play["migrated"].(bool); migrated {
+		return nil, "", nil
+	}
+	if counted, _ := play["counted"].(bool); counted {
+		return nil, "", nil
+	}
+	// skipped plays aren't real listens, they are counted separately
+	if skipped, _ := play["skipped"].(bool); skipped {
+		return map[string]int{"skips": 1}, "", nil
+	}
+	counters := map[string]int{"count": 1}
+	playedAt, _ := play["played_at"].(time.Time)
+	day := playedAt.UTC().Format("2006-01-02")
+	plays := []string{}
+	for _, w := range windows {
+		if day >= windowStart(now, w.days) {
+			counters[w.count] = 1
+			plays = append(plays, w.plays)
+		}
+	}
+	return counters, day, plays
+}
+
+// windowStart - first day (UTC) of window of days ending today
+func windowStart(now time.Time, days int) string {
+	return now.UTC().AddDate(0, 0, 1-days).Format("2006-01-02")
+}
+
 func initFirestoreDatabase(ctx context.Context) *firestore.Client {
 	// use Cloud credentials and roles
 	firestoreClient, err := firestore.NewClient(ctx, firestore.DetectProjectID)
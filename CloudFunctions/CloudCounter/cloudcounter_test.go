@@ -0,0 +1,103 @@
+package beancounter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCountPlay(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time { return now.AddDate(0, 0, -days) }
+	play := func(playedAt time.Time) map[string]interface{} {
+		return map[string]interface{}{"played_at": playedAt}
+	}
+	tests := []struct {
+		name       string
+		plays      []map[string]interface{} // events delivered, same map is the same play
+		wantCounts map[string]int
+		wantDays   map[string]int // plays in maps of day documents (in_7d@YYYY-MM-DD)
+	}{
+		{
+			name:       "today",
+			plays:      []map[string]interface{}{play(now)},
+			wantCounts: map[string]int{"count": 1, "count_7d": 1, "count_30d": 1},
+			wantDays:   map[string]int{"in_7d@2024-03-31": 1, "in_30d@2024-03-31": 1},
+		},
+		{
+			name:       "last day of 7d window",
+			plays:      []map[string]interface{}{play(daysAgo(6))},
+			wantCounts: map[string]int{"count": 1, "count_7d": 1, "count_30d": 1},
+			wantDays:   map[string]int{"in_7d@2024-03-25": 1, "in_30d@2024-03-25": 1},
+		},
+		{
+			name:       "out of 7d but within 30d",
+			plays:      []map[string]interface{}{play(daysAgo(7))},
+			wantCounts: map[string]int{"count": 1, "count_30d": 1},
+			wantDays:   map[string]int{"in_30d@2024-03-24": 1},
+		},
+		{
+			name:       "out of both windows",
+			plays:      []map[string]interface{}{play(daysAgo(30))},
+			wantCounts: map[string]int{"count": 1},
+			wantDays:   map[string]int{},
+		},
+		{
+			name:       "skipped",
+			plays:      []map[string]interface{}{{"played_at": now, "skipped": true}},
+			wantCounts: map[string]int{"skips": 1},
+			wantDays:   map[string]int{},
+		},
+		{
+			name:       "migrated from legacy history",
+			plays:      []map[string]interface{}{{"played_at": now, "migrated": true}},
+			wantCounts: map[string]int{},
+			wantDays:   map[string]int{},
+		},
+		{
+			name:       "counted already",
+			plays:      []map[string]interface{}{{"played_at": now, "counted": true}},
+			wantCounts: map[string]int{},
+			wantDays:   map[string]int{},
+		},
+	}
+	// the same play delivered twice is counted once
+	twice := play(now)
+	tests = append(tests, struct {
+		name       string
+		plays      []map[string]interface{}
+		wantCounts map[string]int
+		wantDays   map[string]int
+	}{
+		name:       "delivered twice",
+		plays:      []map[string]interface{}{twice, twice, play(daysAgo(1))},
+		wantCounts: map[string]int{"count": 2, "count_7d": 2, "count_30d": 2},
+		wantDays: map[string]int{"in_7d@2024-03-31": 1, "in_30d@2024-03-31": 1,
+			"in_7d@2024-03-30": 1, "in_30d@2024-03-30": 1},
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counts := map[string]int{}
+			days := map[string]int{}
+			for _, p := range tt.plays {
+				counters, day, plays := countPlay(p, now)
+				if counters == nil {
+					continue
+				}
+				for field, n := range counters {
+					counts[field] += n
+				}
+				for _, field := range plays {
+					days[field+"@"+day]++
+				}
+				p["counted"] = true // as the transaction does
+			}
+			if !reflect.DeepEqual(counts, tt.wantCounts) {
+				t.Errorf("counters = %v, want %v", counts, tt.wantCounts)
+			}
+			if !reflect.DeepEqual(days, tt.wantDays) {
+				t.Errorf("day plays = %v, want %v", days, tt.wantDays)
+			}
+		})
+	}
+}
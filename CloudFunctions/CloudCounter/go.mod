@@ -5,4 +5,5 @@ go 1.14
 require (
 	cloud.google.com/go/firestore v1.3.0
 	firebase.google.com/go v3.13.0+incompatible
+	google.golang.org/grpc v1.30.0
 )
@@ -1,31 +1,63 @@
 package cloudrecent
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/zmb3/spotify"
+	"go-spotify/common/plays"
+	"go-spotify/common/tokenkeys"
 	"golang.org/x/oauth2"
 )
 
 type firestoreToken struct {
-	user  string
-	path  string
-	token *oauth2.Token
+	user   string
+	path   string
+	token  *oauth2.Token
+	scopes []string // granted scopes (nil if unknown)
+}
+
+// users/{userID}/tokens/{path} document (same as in main app)
+type storedToken struct {
+	AccessToken  string    `firestore:"AccessToken"`
+	TokenType    string    `firestore:"TokenType"`
+	RefreshToken string    `firestore:"RefreshToken"`
+	Expiry       time.Time `firestore:"Expiry"`
+	KeyID        string    `firestore:"key_id"`
+	WrappedKey   []byte    `firestore:"wrapped_key"`
+	Scopes       []string  `firestore:"scopes"`
 }
 
 var (
 	ctx             = context.Background()
 	firestoreClient *firestore.Client
-	pageLimit       = 25
+	tokenKeys       *tokenkeys.Keyring // nil if tokens are kept in plaintext
 	redirectURI     = os.Getenv("REDIRECT_URI")
 	auth            = spotify.NewAuthenticator(redirectURI, spotify.ScopeUserReadPrivate, spotify.ScopeUserTopRead, spotify.ScopeUserLibraryRead, spotify.ScopeUserFollowRead, spotify.ScopeUserReadRecentlyPlayed, spotify.ScopePlaylistModifyPublic, spotify.ScopePlaylistModifyPrivate)
+	// same as INGEST_DEDUP_WINDOW and SKIP_THRESHOLD of main app
+	dedupWindow   = 30 * time.Second
+	skipThreshold = 0.5
+	// Authenticator keeps oauth2 config to itself so we need our own
+	// for wrapping token source (refreshing only, scopes don't matter)
+	oauthConfig = &oauth2.Config{
+		ClientID:     os.Getenv("SPOTIFY_ID"),
+		ClientSecret: os.Getenv("SPOTIFY_SECRET"),
+		RedirectURL:  redirectURI,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  spotify.AuthURL,
+			TokenURL: spotify.TokenURL,
+		},
+	}
 )
 
 func main() {
@@ -35,6 +67,20 @@ func main() {
 func init() {
 	ctx := context.Background()
 	firestoreClient = initFirestoreDatabase(ctx)
+	var err error
+	if tokenKeys, err = tokenkeys.Load(os.Getenv("TOKEN_KEYS"), os.Getenv("TOKEN_KEY_ID")); err != nil {
+		log.Panic(err)
+	}
+	if s := os.Getenv("INGEST_DEDUP_WINDOW"); s != "" {
+		if dedupWindow, err = time.ParseDuration(s); err != nil {
+			log.Panic(err)
+		}
+	}
+	if s := os.Getenv("SKIP_THRESHOLD"); s != "" {
+		if skipThreshold, err = strconv.ParseFloat(s, 64); err != nil {
+			log.Panic(err)
+		}
+	}
 }
 
 /*CloudRecent - ..
@@ -52,14 +98,25 @@ func CloudRecent(w http.ResponseWriter, r *http.Request) {
 		userCounter := 0
 		for _, doc := range docs {
 			user := doc.Data()["userID"].(string)
+			// de-authorized or otherwise useless token
+			if status, ok := doc.Data()["token_status"].(string); ok && tokenQuarantined(status) {
+				log.Printf("Skipping user: %s token is %s", user, status)
+				continue
+			}
 			log.Printf("Multiple user: %s", user)
 			trackCounter += processUser(user)
 			userCounter++
 		}
 		log.Printf("Processed %d tracks for %d users", trackCounter, userCounter)
 	} else { // for single user
-		pageLimit = 50
 		user := users[0]
+		if dsnap, err := firestoreClient.Collection("users").Doc(user).Get(ctx); err == nil {
+			if status, ok := dsnap.Data()["token_status"].(string); ok && tokenQuarantined(status) {
+				log.Printf("Skipping user: %s token is %s", user, status)
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
 		log.Printf("Single user: %s", user)
 		trackCounter := processUser(user)
 		log.Printf("Processed %d tracks for %s", trackCounter, user)
@@ -71,48 +128,99 @@ func CloudRecent(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+/*processUser - saves tracks user has played since ingestion cursor
+(played_at of the newest saved track, kept in user document)
+*/
 func processUser(user string) int {
-	var spotifyClient spotify.Client
 	var newTok firestoreToken
 	trackCounter := 0
 
+	var cursor time.Time
+	var last *plays.Item
+	userRef := firestoreClient.Collection("users").Doc(user)
+	if dsnap, err := userRef.Get(ctx); err == nil {
+		if c, ok := dsnap.Data()["ingest_cursor"].(time.Time); ok {
+			cursor = c
+		}
+		if id, ok := dsnap.Data()["ingest_last_id"].(string); ok && id != "" {
+			last = &plays.Item{ID: id, PlayedAt: cursor}
+		}
+	}
 	newTok.user = user
 	newTok.path = "/user"
 	tok, err := getTokenFromDB(&newTok)
 	if err != nil {
 		log.Println(err.Error())
+		return trackCounter
 	}
-	spotifyClient = auth.NewClient(tok)
-	options := new(spotify.RecentlyPlayedOptions)
-	options.Limit = pageLimit
-	recentlyPlayed, err := spotifyClient.PlayerRecentlyPlayedOpt(options)
+	spotifyClient, src := newClient(tok, user, newTok.path)
+	recentlyPlayed, gap, err := plays.After(fetchRecentlyPlayed(spotifyClient), cursor)
 	if err != nil {
-		log.Panic(err)
+		log.Printf("Error getting recently played for %s %s", user, err.Error())
+		if status := classifyTokenError(err, src.refreshed, newTok.scopes); status != "" {
+			quarantineToken(user, status)
+		}
+		return trackCounter
+	}
+	if gap {
+		log.Printf("Probable gap in history of %s - more than %d plays since %s", user, plays.Limit, cursor.Format(time.RFC3339))
+	}
+	// the newest play may be left for the next call (see plays.Normalize)
+	normalized := plays.Normalize(last, recentlyPlayed, dedupWindow, skipThreshold, time.Now())
+	if len(normalized) == 0 {
+		return trackCounter
 	}
 	// Get a new write batch.
-	path := fmt.Sprintf("users/%s/recently_played", user)
+	path := fmt.Sprintf("users/%s/plays", user)
 	batch := firestoreClient.Batch()
-	for _, item := range recentlyPlayed {
-		artists := joinArtists(item.Track.Artists, ", ")
-		playedAt := item.PlayedAt
-		recentlyPlayedRef := firestoreClient.Collection(path).Doc(string(item.Track.ID))
-		batch.Set(recentlyPlayedRef, map[string]interface{}{
-			"played_at":  playedAt,
-			"track_name": item.Track.Name,
-			"artists":    artists,
-			"id":         string(item.Track.ID),
-		}, firestore.MergeAll) // Overwrite only the fields in the map; preserve all others.
+	for _, play := range normalized {
+		// one document per play - played_at (ms) plus track ID, merged
+		// so saving it again keeps counted flag of CloudCounter
+		batch.Set(firestoreClient.Collection(path).Doc(plays.ID(play.PlayedAt, play.ID)), map[string]interface{}{
+			"played_at":   play.PlayedAt,
+			"track_name":  play.Name,
+			"artists":     play.Artists,
+			"id":          play.ID,
+			"duration_ms": play.Duration,
+			"skipped":     play.Skipped,
+		}, firestore.MergeAll)
 		trackCounter++
 	}
+	newest := normalized[len(normalized)-1]
+	// cursor is moved in the same batch so it never runs ahead of saved tracks
+	batch.Set(userRef, map[string]interface{}{"ingest_cursor": newest.PlayedAt, "ingest_last_id": newest.ID}, firestore.MergeAll)
 	// Commit the batch.
 	_, errBatch := batch.Commit(ctx)
 	if errBatch != nil {
 		// Handle any errors in an appropriate way, such as returning them.
-		log.Printf("An error while commiting batch to firestore: %s", err.Error())
+		log.Printf("An error while commiting batch to firestore: %s", errBatch.Error())
+		return 0
 	}
 	return trackCounter
 }
 
+/*fetchRecentlyPlayed - fetches pages of recently played tracks for plays.After
+ */
+func fetchRecentlyPlayed(spotifyClient spotify.Client) func(int64) ([]plays.Item, error) {
+	return func(afterMs int64) ([]plays.Item, error) {
+		got, err := spotifyClient.PlayerRecentlyPlayedOpt(&spotify.RecentlyPlayedOptions{Limit: plays.Limit, AfterEpochMs: afterMs})
+		if err != nil {
+			return nil, err
+		}
+		items := []plays.Item{}
+		for _, item := range got {
+			items = append(items, plays.Item{
+				Name:     item.Track.Name,
+				Artists:  joinArtists(item.Track.Artists, ", "),
+				PlayedAt: item.PlayedAt,
+				ID:       string(item.Track.ID),
+				Duration: item.Track.Duration,
+			})
+		}
+		return items, nil
+	}
+}
+
 func getTokenFromDB(token *firestoreToken) (*oauth2.Token, error) {
 	path := fmt.Sprintf("users/%s/tokens%s", token.user, token.path)
 	dsnap, err := firestoreClient.Doc(path).Get(ctx)
@@ -120,13 +228,163 @@ func getTokenFromDB(token *firestoreToken) (*oauth2.Token, error) {
 		log.Printf("Error retrieving token from Firestore for %s %s.\nPossibly it ain't there..", path, err.Error())
 		return nil, err
 	}
-	tok := &oauth2.Token{}
-	dsnap.DataTo(tok)
+	stored := &storedToken{}
+	if err := dsnap.DataTo(stored); err != nil {
+		return nil, err
+	}
+	opened, err := tokenKeys.Open(tokenkeys.Record{
+		User:         token.user,
+		Path:         "tokens" + token.path, // same as tokenPath in main app
+		AccessToken:  stored.AccessToken,
+		RefreshToken: stored.RefreshToken,
+		KeyID:        stored.KeyID,
+		WrappedKey:   stored.WrappedKey,
+	})
+	if err != nil {
+		log.Printf("Error decrypting token %s %s", path, err.Error())
+		return nil, err
+	}
+	tok := &oauth2.Token{
+		AccessToken:  opened.AccessToken,
+		TokenType:    stored.TokenType,
+		RefreshToken: opened.RefreshToken,
+		Expiry:       stored.Expiry,
+	}
 	token.token = tok
+	token.scopes = stored.Scopes
 	// log.Printf("getTokenFromDB: Got token with expiration %s", tok.Expiry.In(location).Format("15:04:05"))
 	return tok, nil
 }
 
+/*persistentTokenSource - saves token into Firestore every time it gets refreshed
+ */
+type persistentTokenSource struct {
+	src       oauth2.TokenSource
+	user      string
+	path      string
+	last      *oauth2.Token
+	refreshed bool // token has been refreshed in this invocation
+}
+
+func (s *persistentTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok.AccessToken != s.last.AccessToken || tok.RefreshToken != s.last.RefreshToken {
+		s.last = tok
+		s.refreshed = true
+		if err := updateTokenInDB(s.user, s.path, tok); err != nil {
+			log.Printf("Error saving refreshed token for %s%s %s", s.user, s.path, err.Error())
+		}
+	}
+	return tok, nil
+}
+
+/*newClient - Spotify client persisting refreshed tokens and its token source
+ */
+func newClient(tok *oauth2.Token, user string, path string) (spotify.Client, *persistentTokenSource) {
+	src := &persistentTokenSource{
+		src:  oauthConfig.TokenSource(ctx, tok),
+		user: user,
+		path: path,
+		last: tok,
+	}
+	return spotify.NewClient(oauth2.NewClient(ctx, src)), src
+}
+
+/*updateTokenInDB - saves token in a transaction so parallel invocations
+(or main app) refreshing the same token don't overwrite newer one
+*/
+func updateTokenInDB(user string, path string, tok *oauth2.Token) error {
+	sealed, err := tokenKeys.Seal(tokenkeys.Record{
+		User:         user,
+		Path:         "tokens" + path, // same as tokenPath in main app
+		AccessToken:  tok.AccessToken,
+		RefreshToken: tok.RefreshToken,
+	})
+	if err != nil {
+		return err
+	}
+	docRef := firestoreClient.Doc(fmt.Sprintf("users/%s/tokens%s", user, path))
+	return firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		dsnap, err := tx.Get(docRef)
+		if err == nil {
+			stored := &oauth2.Token{}
+			if err := dsnap.DataTo(stored); err == nil && stored.Expiry.After(tok.Expiry) {
+				return nil
+			}
+		}
+		return tx.Set(docRef, map[string]interface{}{
+			"AccessToken":  sealed.AccessToken,
+			"Expiry":       tok.Expiry,
+			"RefreshToken": sealed.RefreshToken,
+			"TokenType":    tok.TokenType,
+			"key_id":       sealed.KeyID,
+			"wrapped_key":  sealed.WrappedKey,
+		}, firestore.MergeAll)
+	})
+}
+
+// token status kept in users/{userID} document (same as in main app)
+const (
+	tokenRevoked       = "revoked"
+	tokenScopeMismatch = "scope-mismatch"
+)
+
+func tokenQuarantined(status string) bool {
+	return status == tokenRevoked || status == tokenScopeMismatch
+}
+
+/*classifyTokenError - returns token status if error means our
+authorization is dead or "" otherwise (same rules as main app):
+refresh token not accepted (invalid_grant), 401 although token has
+just been refreshed, or 403 about scope when granted scopes lack
+the one we need
+*/
+func classifyTokenError(err error, refreshed bool, granted []string) string {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		if bytes.Contains(retrieveErr.Body, []byte("invalid_grant")) {
+			return tokenRevoked
+		}
+		return ""
+	}
+	var spotifyErr spotify.Error
+	if errors.As(err, &spotifyErr) {
+		switch {
+		case spotifyErr.Status == http.StatusUnauthorized && refreshed:
+			return tokenRevoked
+		case spotifyErr.Status == http.StatusForbidden && strings.Contains(strings.ToLower(spotifyErr.Message), "scope") &&
+			granted != nil && !contains(granted, spotify.ScopeUserReadRecentlyPlayed):
+			return tokenScopeMismatch
+		}
+	}
+	return ""
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+/*quarantineToken - marks user's token so we stop bothering Spotify with it
+ */
+func quarantineToken(user string, status string) {
+	log.Printf("Token of %s is %s", user, status)
+	_, err := firestoreClient.Collection("users").Doc(user).Set(ctx, map[string]interface{}{
+		"token_status":         status,
+		"token_status_updated": time.Now(),
+	}, firestore.MergeAll)
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
 func joinArtists(artists []spotify.SimpleArtist, separator string) string {
 	return strings.Join(
 		func() []string {
@@ -31,3 +31,8 @@ require (
 	google.golang.org/grpc v1.50.1 // indirect
 	google.golang.org/protobuf v1.28.1 // indirect
 )
+
+require go-spotify/common v0.0.0
+
+// shared with main app, run go mod vendor before deploying
+replace go-spotify/common => ../../common
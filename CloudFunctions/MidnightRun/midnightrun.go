@@ -6,6 +6,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/firestore"
@@ -15,20 +19,31 @@ import (
 var (
 	firestoreClient *firestore.Client
 	ctx             = context.Background()
+	clientOnce      sync.Once // client is made on first run (not in init, so tests don't need credentials)
+	// days plays are kept (user's shorter retention_days wins), set in init
+	freeRetentionDays    = 7
+	premiumRetentionDays = 90
+	// copy of requiredScopes from the main app - keep them in sync
+	requiredScopes = []string{"user-read-email", "user-read-private", "user-top-read", "user-library-read", "user-follow-read", "user-read-recently-played", "playlist-modify-public", "playlist-modify-private", "playlist-read-collaborative", "playlist-read-private"}
 )
 
 func main() {
 }
 
 func init() {
-	ctx := context.Background()
-	firestoreClient = initFirestoreDatabase(ctx)
+	if days, err := strconv.Atoi(os.Getenv("RETENTION_DAYS")); err == nil && days > 0 {
+		freeRetentionDays = days
+	}
+	if days, err := strconv.Atoi(os.Getenv("PREMIUM_RETENTION_DAYS")); err == nil && days > 0 {
+		premiumRetentionDays = days
+	}
 }
 
 /*
 MidnightRun - does litmus configuration
 also takes care of database maintenance
-It removes all tracks listened to more then a week ago
+It rolls up into monthly summaries and removes all tracks listened
+to before user's retention (see retentionDays)
 TODO - it should also clean unused and failed tokens
 and users who stoped using service
 As the name suggest it is supposed to run once a day at
@@ -37,6 +52,7 @@ midnight. But no hassle to run it by hand.
 PS. The movie Midnight Run is great https://youtu.be/LF8cT6ivlr4
 */
 func MidnightRun(w http.ResponseWriter, r *http.Request) {
+	clientOnce.Do(func() { firestoreClient = initFirestoreDatabase(ctx) })
 	defer firestoreClient.Close()
 	{
 		counter := 0
@@ -80,44 +96,22 @@ func MidnightRun(w http.ResponseWriter, r *http.Request) {
 		}
 		for _, doc := range docs {
 			user := doc.Data()["userID"].(string) // legit would to read data and get userID
-			log.Printf("user: %s", user)
-			batchSize := 50
-			path := fmt.Sprintf("users/%s/recently_played", user)
-			ref := firestoreClient.Collection(path).Where("played_at", "<", time.Now().AddDate(0, 0, -7)) // 7 days
-			for {
-				// Get a batch of documents
-				iter := ref.Limit(batchSize).Documents(ctx)
-				numDeleted := 0
-				// Iterate through the documents, adding a delete operation
-				// for each one to a WriteBatch.
-				batch := firestoreClient.Batch()
-				for {
-					iterDoc, err := iter.Next()
-					if err == iterator.Done {
-						break
-					}
-					if err != nil {
-						log.Println(err.Error())
-					}
-					batch.Delete(iterDoc.Ref)
-					numDeleted++
-				}
-				trackCounter += numDeleted
-				log.Printf("Deleted: %d tracks", numDeleted)
-				// If there are no documents to delete, the process is over.
-				if numDeleted == 0 {
-					break
-				}
-				// Commit the batch.
-				_, err := batch.Commit(ctx)
-				if err != nil {
-					log.Printf("An error while commiting batch to firestore: %s", err.Error())
-				}
+			days := retentionDays(doc.Data())
+			log.Printf("user: %s keeps %d days", user, days)
+			numDeleted, err := purgePlays(user, time.Now().AddDate(0, 0, -days))
+			if err != nil {
+				log.Printf("An error while purging plays of %s: %s", user, err.Error())
+			}
+			trackCounter += numDeleted
+			log.Printf("Deleted: %d tracks", numDeleted)
+			if err := expireWindows(user, time.Now()); err != nil {
+				log.Printf("An error while expiring popularity windows of %s: %s", user, err.Error())
 			}
 			userCounter++
 		}
 	}
 	log.Printf("Processed %d tracks for %d users", trackCounter, userCounter)
+	staleTokens()
 	w.WriteHeader(http.StatusOK)
 	response := "Midnight Run - starring Robert DeNiro"
 	if err := json.NewEncoder(w).Encode(&response); err != nil {
@@ -125,6 +119,260 @@ func MidnightRun(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+/*retentionDays - plan default (RETENTION_DAYS, PREMIUM_RETENTION_DAYS)
+or user's retention_days if it is shorter - same as in main app
+*/
+func retentionDays(user map[string]interface{}) int {
+	plan := freeRetentionDays
+	expires, _ := user["subscription_expires"].(time.Time)
+	if premium, _ := user["premium_user"].(bool); premium && (expires.IsZero() || expires.After(time.Now())) {
+		plan = premiumRetentionDays
+	}
+	if days, ok := user["retention_days"].(int64); ok && days > 0 && int(days) < plan {
+		return int(days)
+	}
+	return plan
+}
+
+/*purgePlays - adds plays older than before to monthly rollups
+users/{user}/rollups/{YYYY-MM} (UTC) and deletes them in the same
+batch, so nothing is counted twice if we fail halfway (same as in main app)
+*/
+func purgePlays(user string, before time.Time) (int, error) {
+	path := fmt.Sprintf("users/%s/plays", user)
+	query := firestoreClient.Collection(path).Where("played_at", "<", before).OrderBy("played_at", firestore.Asc)
+	n := 0
+	for {
+		// leaves room in batch for rollups
+		docs, err := query.Limit(400).Documents(ctx).GetAll()
+		if err != nil {
+			return n, err
+		}
+		if len(docs) == 0 {
+			return n, nil
+		}
+		batch := firestoreClient.Batch()
+		rollups := map[string]*rollup{}
+		for _, doc := range docs {
+			var p play
+			if err := doc.DataTo(&p); err != nil {
+				log.Printf("Skipping %s %s", doc.Ref.Path, err.Error())
+			} else {
+				month := p.PlayedAt.UTC().Format("2006-01")
+				if rollups[month] == nil {
+					rollups[month] = &rollup{tracks: map[string]int{}, artists: map[string]int{}}
+				}
+				rollups[month].add(p)
+			}
+			batch.Delete(doc.Ref)
+		}
+		for month, r := range rollups {
+			ref := firestoreClient.Collection(fmt.Sprintf("users/%s/rollups", user)).Doc(month)
+			batch.Set(ref, r.fields(month), firestore.MergeAll)
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return n, err
+		}
+		n += len(docs)
+	}
+}
+
+// users/{user}/plays/{playedAtMs_trackID} document
+type play struct {
+	Artists  string    `firestore:"artists"`
+	PlayedAt time.Time `firestore:"played_at"`
+	ID       string    `firestore:"id"`
+	Duration int       `firestore:"duration_ms"`
+	Skipped  bool      `firestore:"skipped"`
+}
+
+// counters of a month (users/{user}/rollups/{YYYY-MM} document)
+type rollup struct {
+	plays, skips int
+	minutes      float64
+	tracks       map[string]int // listens by track ID
+	artists      map[string]int // listens by artist name
+}
+
+func (r *rollup) add(p play) {
+	if p.Skipped {
+		r.skips++
+		return
+	}
+	r.plays++
+	r.minutes += float64(p.Duration) / 60000
+	if p.ID != "" {
+		r.tracks[p.ID]++
+	}
+	for _, artist := range strings.Split(p.Artists, ", ") {
+		if artist = strings.TrimSpace(artist); artist != "" {
+			r.artists[artist]++
+		}
+	}
+}
+
+/*fields - rollup as Firestore increments (added to what has been rolled up before)
+ */
+func (r *rollup) fields(month string) map[string]interface{} {
+	fields := map[string]interface{}{
+		"month":   month,
+		"plays":   firestore.Increment(r.plays),
+		"skips":   firestore.Increment(r.skips),
+		"minutes": firestore.Increment(r.minutes),
+	}
+	tracks := map[string]interface{}{}
+	for id, n := range r.tracks {
+		tracks[id] = firestore.Increment(n)
+	}
+	artists := map[string]interface{}{}
+	for artist, n := range r.artists {
+		artists[artist] = firestore.Increment(n)
+	}
+	// empty map would replace what is there
+	if len(tracks) > 0 {
+		fields["tracks"] = tracks
+	}
+	if len(artists) > 0 {
+		fields["artists"] = artists
+	}
+	return fields
+}
+
+// popularity windows (days, including today UTC) - same as in CloudCounter
+var windows = []struct {
+	days         int
+	count, plays string // counter field and map of plays in day document
+	expired      string // flag set on day document once subtracted
+}{
+	{7, "count_7d", "in_7d", "expired_7d"},
+	{30, "count_30d", "in_30d", "expired_30d"},
+}
+
+/*expireWindows - subtracts plays of days which have left popularity
+windows from windowed counters of popular_tracks. Day document is
+flagged in the same transaction so nothing is subtracted twice and
+deleted when it has left the longest window. Days missed (if we
+haven't run for a while) are caught up.
+*/
+func expireWindows(user string, now time.Time) error {
+	path := fmt.Sprintf("users/%s/popular_days", user)
+	docs, err := firestoreClient.Collection(path).Where("day", "<", windowStart(now, windows[0].days)).Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			snap, err := tx.Get(doc.Ref)
+			if err != nil {
+				return err
+			}
+			decrements, flags, remove := expireDay(snap.Data(), now)
+			for id, counters := range decrements {
+				fields := map[string]interface{}{}
+				for field, n := range counters {
+					fields[field] = firestore.Increment(-n)
+				}
+				ref := firestoreClient.Collection(fmt.Sprintf("users/%s/popular_tracks", user)).Doc(id)
+				if err := tx.Set(ref, fields, firestore.MergeAll); err != nil {
+					return err
+				}
+			}
+			if remove {
+				return tx.Delete(doc.Ref)
+			}
+			if len(flags) == 0 {
+				return nil
+			}
+			updates := []firestore.Update{}
+			for _, flag := range flags {
+				updates = append(updates, firestore.Update{Path: flag, Value: true})
+			}
+			return tx.Update(doc.Ref, updates)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*expireDay - plays to subtract from counters of tracks (by track ID) for
+windows day document has left since it was flagged last time, windows to
+flag now and whether the day has left all windows (and can be deleted)
+*/
+func expireDay(day map[string]interface{}, now time.Time) (map[string]map[string]int64, []string, bool) {
+	dayStr, _ := day["day"].(string)
+	decrements := map[string]map[string]int64{}
+	flags := []string{}
+	expired := 0
+	for _, w := range windows {
+		if done, _ := day[w.expired].(bool); done {
+			expired++
+			continue
+		}
+		if dayStr >= windowStart(now, w.days) {
+			continue
+		}
+		plays, _ := day[w.plays].(map[string]interface{})
+		for id, n := range plays {
+			count, _ := n.(int64)
+			if decrements[id] == nil {
+				decrements[id] = map[string]int64{}
+			}
+			decrements[id][w.count] = count
+		}
+		flags = append(flags, w.expired)
+		expired++
+	}
+	return decrements, flags, expired == len(windows)
+}
+
+// windowStart - first day (UTC) of window of days ending today
+func windowStart(now time.Time, days int) string {
+	return now.UTC().AddDate(0, 0, 1-days).Format("2006-01-02")
+}
+
+/*staleTokens - reports how many stored tokens lack scopes we
+require now (their users will be asked to re-authorize on next visit)
+and how many were saved before we started keeping granted scopes
+*/
+func staleTokens() {
+	stale, unknown, total := 0, 0, 0
+	iter := firestoreClient.CollectionGroup("tokens").Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("An error retrieving tokens: %s", err.Error())
+			return
+		}
+		total++
+		granted, ok := doc.Data()["scopes"].([]interface{})
+		if !ok {
+			unknown++
+			continue
+		}
+		for _, scope := range requiredScopes {
+			found := false
+			for _, g := range granted {
+				if g == scope {
+					found = true
+					break
+				}
+			}
+			if !found {
+				log.Printf("Stale token: %s lacks %s", doc.Ref.Path, scope)
+				stale++
+				break
+			}
+		}
+	}
+	log.Printf("Tokens: %d stored, %d stale, %d with unknown scopes", total, stale, unknown)
+}
+
 func initFirestoreDatabase(ctx context.Context) *firestore.Client {
 	// use Cloud credentials and roles
 	firestoreClient, err := firestore.NewClient(ctx, firestore.DetectProjectID)
@@ -0,0 +1,100 @@
+package midnightrun
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpireDay(t *testing.T) {
+	played := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	after := func(days int) time.Time { return played.AddDate(0, 0, days) }
+	day := func(fields map[string]interface{}) map[string]interface{} {
+		d := map[string]interface{}{
+			"day":    "2024-03-01",
+			"in_7d":  map[string]interface{}{"a": int64(2), "b": int64(1)},
+			"in_30d": map[string]interface{}{"a": int64(2), "b": int64(1)},
+		}
+		for k, v := range fields {
+			d[k] = v
+		}
+		return d
+	}
+	tests := []struct {
+		name           string
+		day            map[string]interface{}
+		now            time.Time
+		wantDecrements map[string]map[string]int64
+		wantFlags      []string
+		wantRemove     bool
+	}{
+		{
+			name:           "within both windows",
+			day:            day(nil),
+			now:            after(6),
+			wantDecrements: map[string]map[string]int64{},
+			wantFlags:      []string{},
+		},
+		{
+			name: "out of 7d but not 30d",
+			day:  day(nil),
+			now:  after(7),
+			wantDecrements: map[string]map[string]int64{
+				"a": {"count_7d": 2},
+				"b": {"count_7d": 1},
+			},
+			wantFlags: []string{"expired_7d"},
+		},
+		{
+			name:           "7d expired already",
+			day:            day(map[string]interface{}{"expired_7d": true}),
+			now:            after(8),
+			wantDecrements: map[string]map[string]int64{},
+			wantFlags:      []string{},
+		},
+		{
+			name: "out of 30d after 7d expired",
+			day:  day(map[string]interface{}{"expired_7d": true}),
+			now:  after(30),
+			wantDecrements: map[string]map[string]int64{
+				"a": {"count_30d": 2},
+				"b": {"count_30d": 1},
+			},
+			wantFlags:  []string{"expired_30d"},
+			wantRemove: true,
+		},
+		{
+			name: "out of both at once (missed runs)",
+			day:  day(nil),
+			now:  after(40),
+			wantDecrements: map[string]map[string]int64{
+				"a": {"count_7d": 2, "count_30d": 2},
+				"b": {"count_7d": 1, "count_30d": 1},
+			},
+			wantFlags:  []string{"expired_7d", "expired_30d"},
+			wantRemove: true,
+		},
+		{
+			name:           "both expired already",
+			day:            day(map[string]interface{}{"expired_7d": true, "expired_30d": true}),
+			now:            after(40),
+			wantDecrements: map[string]map[string]int64{},
+			wantFlags:      []string{},
+			wantRemove:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decrements, flags, remove := expireDay(tt.day, tt.now)
+			if !reflect.DeepEqual(decrements, tt.wantDecrements) {
+				t.Errorf("expireDay() decrements = %v, want %v", decrements, tt.wantDecrements)
+			}
+			if !reflect.DeepEqual(flags, tt.wantFlags) {
+				t.Errorf("expireDay() flags = %v, want %v", flags, tt.wantFlags)
+			}
+			if remove != tt.wantRemove {
+				t.Errorf("expireDay() remove = %v, want %v", remove, tt.wantRemove)
+			}
+		})
+	}
+}
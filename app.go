@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Cloud Run gives us 10 seconds after SIGTERM
+const shutdownTimeout = 8 * time.Second
+
+/*application - HTTP server together with everything it needs
+(database, cache) and background work it has started.
+Create it with newApplication, start with Run and stop with Shutdown
+(Run calls Shutdown itself when its context is done).
+*/
+type application struct {
+	server    *http.Server
+	scheduler *ingestScheduler // nil if polling is off
+	purge     bool             // apply retention daily (there is no MidnightRun)
+	// background work is cancelled by Shutdown and waited for
+	background context.Context
+	cancel     context.CancelFunc
+	mu         sync.Mutex // guards closing and wg.Add against wg.Wait
+	closing    bool
+	wg         sync.WaitGroup
+	once       sync.Once
+	err        error
+}
+
+/*newApplication - validates config, opens database and cache,
+builds router and history ingestion scheduler
+*/
+func newApplication(conf *Config) (*application, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	auth.SetAuthInfo(conf.SpotifyID, conf.SpotifySecret) // config file could have them
+	db = initStore(ctx)
+	shared = initCache()
+	a := &application{}
+	a.background, a.cancel = context.WithCancel(context.Background())
+	// A zero/default http.Server, like the one used by the package-level helpers
+	// http.ListenAndServe and http.ListenAndServeTLS, comes with no timeouts.
+	// You don't want that.
+	a.server = &http.Server{
+		Addr:              conf.listenAddr(),
+		Handler:           newRouter(conf),
+		ReadHeaderTimeout: 3 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      25 * time.Second,
+		IdleTimeout:       90 * time.Second,
+	}
+	if conf.IngestInterval > 0 {
+		a.scheduler = newIngestScheduler(conf.IngestInterval, conf.IngestConcurrency)
+	}
+	a.purge = conf.StoreBackend == "bolt"
+	app = a
+	return a, nil
+}
+
+/*Run - serves until ctx is done (or server fails) and shuts down
+ */
+func (a *application) Run(ctx context.Context) error {
+	if a.scheduler != nil {
+		a.Go(a.scheduler.Run)
+	}
+	if a.purge {
+		a.Go(retentionLoop)
+	}
+	if cfg.ScrobbleInterval > 0 {
+		a.Go(scrobbleLoop)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", a.server.Addr)
+		errc <- a.server.ListenAndServe()
+	}()
+	var err error
+	select {
+	case err = <-errc:
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+	case <-ctx.Done():
+		log.Println("Shutting down")
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if shutdownErr := a.Shutdown(shutdownCtx); err == nil {
+		err = shutdownErr
+	}
+	return err
+}
+
+/*Shutdown - stops accepting requests and drains those in flight,
+cancels background work and waits for it (so its database writes
+are done) and closes cache and database. If background work doesn't
+finish in time cache and database are left open - it may still be writing
+and the process is about to exit anyway. Safe to call more than once.
+*/
+func (a *application) Shutdown(ctx context.Context) error {
+	a.once.Do(func() {
+		a.err = a.server.Shutdown(ctx)
+		a.mu.Lock()
+		a.closing = true // no a.Go from now on
+		a.mu.Unlock()
+		a.cancel()
+		done := make(chan struct{})
+		go func() {
+			a.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			log.Println("Shutdown: background work didn't finish in time, leaving cache and database open")
+			if a.err == nil {
+				a.err = ctx.Err()
+			}
+			return
+		}
+		if err := shared.Close(); err != nil {
+			log.Printf("Shutdown: closing cache %s", err.Error())
+		}
+		if err := db.Close(); err != nil {
+			log.Printf("Shutdown: closing database %s", err.Error())
+		}
+	})
+	return a.err
+}
+
+/*Go - runs f in background goroutine which Shutdown waits for.
+f should give up when ctx is done.
+*/
+func (a *application) Go(f func(ctx context.Context)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closing {
+		return
+	}
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		f(a.background)
+	}()
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/compute/metadata"
@@ -20,10 +23,10 @@ import (
 )
 
 /*TODO
-The big unknown is what happens when user deauthorizes our app in preferences
-without letting us know
-Token becomes invalid and throws at us errors at each attempt (this is a problem for
-Cloud functions which will still attempt to authorize
+When user deauthorizes our app in preferences without letting us know
+token becomes invalid and throws at us errors at each attempt. Such tokens
+are quarantined (see tokenstatus.go) - background jobs skip the user
+and web flow asks user to authorize the app again.
 - We should also get user country and location
 */
 
@@ -37,17 +40,13 @@ func AuthenticationRequired(authPath string) gin.HandlerFunc {
 		// if user is nil == user is not logged in with Spotify, so start authorization process
 		if user == nil {
 			// c.JSON(http.StatusUnauthorized, gin.H{"error": "user needs to be signed in to access this service"})
-			url := auth.AuthURL(authPath)
-			log.Println("/auth: Please log in to Spotify by visiting the following page in your browser:", url)
-			c.Redirect(303, url)
-			c.Abort()
+			startAuthorization(c, authPath, c.Request.URL.RequestURI(), false)
 			// we call c.Abort() if the user is unauthenticated/unauthorized.
 			// This is because gin calls the next function in the chain even after you write the header
 			return
 		}
-		// add session verification here, like checking if the user and authPath
-		// combination actually exists if necessary. Try adding caching this
-		// since this middleware might be called a lot
+		// session is verified against sessions stored in database only when
+		// there is no client in cache (signing out evicts cached client)
 
 		// if user is set in session authPath and uuid should be (casting to string will fail terribly if nil)
 		userString := user.(string)
@@ -55,28 +54,62 @@ func AuthenticationRequired(authPath string) gin.HandlerFunc {
 			// log.Printf("/auth: Seems like we are legit as user %s for routes group %s: ", userString, authPath)
 		}
 		uuid := session.Get("uuid").(string)
+		if status := quarantinedStatus(userString, false); status != "" {
+			reauthorize(c, status)
+			return
+		}
 		// var client *spotify.Client
 		// We are only checking if there is a client for this session in cache
 		if _, foundClient := kaszka.Get(uuid); foundClient {
 			// client = gclient.(*spotify.Client)
 			// kaszka.Add(uuid, &client, cache.DefaultExpiration) //Add is like Set or refresh
+			if sessionSignedOut(userString, uuid) {
+				kaszka.Delete(uuid)
+				signedOut(c)
+				return
+			}
+			touchSession(userString, uuid)
 		} else { // and if there is no client in cache we get token from Firestore
-			location, _ := time.LoadLocation("Europe/Warsaw")
+			// session could have been signed out from another device,
+			// sessions from before we kept them have no record yet
+			if _, err := db.GetSession(userString, uuid); err == errNotFound {
+				if recorded, _ := session.Get("recorded").(bool); recorded || sessionSignedOut(userString, uuid) {
+					signedOut(c)
+					return
+				}
+				log.Printf("/auth: Recording session %s of %s from before sessions were kept", uuid, userString)
+				recordSession(c, userString, uuid)
+				if err := session.Save(); err != nil {
+					log.Printf("/auth: Error saving session of %s %s", userString, err.Error())
+				}
+			}
+			location := cfg.Location
 			log.Printf("/auth: Cached client NOT found for: %s", uuid)
 			// create client and put it in cache
 			var newTok firestoreToken
 			newTok.user = userString
 			newTok.path = authPath
-			tok, _ := getTokenFromDB(&newTok)
-			log.Printf("/auth: Token expires at: %s", tok.Expiry.In(location).Format("15:04:05"))
-			spotifyClient := auth.NewClient(tok)
-			kaszka.Set(uuid, &spotifyClient, cache.DefaultExpiration)
-			// if token in Firestore is close to or past expiration we refresh token and update in database
-			if m, _ := time.ParseDuration("4m30s"); time.Until(tok.Expiry) < m {
-				newTok.token, _ = spotifyClient.Token()
-				log.Printf("New token expires in: %s", newTok.token.Expiry.Sub(time.Now()).String())
-				updateTokenInDB(&newTok)
+			if status := quarantinedStatus(userString, true); status != "" {
+				reauthorize(c, status)
+				return
+			}
+			tok, err := getTokenFromDB(&newTok)
+			if err != nil { // no token - start authorization process again
+				reauthorize(c, tokenRevoked)
+				return
 			}
+			// we need more than user has granted with this token
+			if missing := missingScopes(newTok.scopes); len(missing) > 0 {
+				log.Printf("/auth: Token of %s lacks scopes %v", userString, missing)
+				quarantineToken(userString, tokenScopeMismatch)
+				reauthorize(c, tokenScopeMismatch)
+				return
+			}
+			log.Printf("/auth: Token expires at: %s", tok.Expiry.In(location).Format("15:04:05"))
+			// client saves token into database by itself whenever it is refreshed
+			spotifyClient := newClient(tok, userString, authPath)
+			kaszka.Set(uuid, spotifyClient, cache.DefaultExpiration)
+			touchSession(userString, uuid)
 		}
 		return
 		// c.Next() //TODO - philosophical question - Is c.Next() needed here?
@@ -84,6 +117,66 @@ func AuthenticationRequired(authPath string) gin.HandlerFunc {
 	}
 }
 
+/*startAuthorization - redirects user to Spotify authorization page.
+Random state and PKCE code verifier are kept in session (together with
+authPath and endpoint to return to) so /callback can check that it
+finishes the flow this browser has started.
+*/
+func startAuthorization(c *gin.Context, authPath string, endpoint string, dialog bool) {
+	state := randomString(32)
+	verifier := randomString(32) // 43 characters - minimum for PKCE
+	session := sessions.Default(c)
+	session.Set("oauthState", state)
+	session.Set("oauthVerifier", verifier)
+	session.Set("oauthPath", authPath)
+	session.Set("oauthEndpoint", endpoint)
+	if err := session.Save(); err != nil {
+		log.Panic(err)
+	}
+	opts := []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		oauth2.SetAuthURLParam("code_challenge", pkceChallenge(verifier)),
+	}
+	if dialog {
+		opts = append(opts, oauth2.SetAuthURLParam("show_dialog", "true"))
+	}
+	url := oauthConfig.AuthCodeURL(state, opts...)
+	log.Println("/auth: Please log in to Spotify by visiting the following page in your browser:", url)
+	c.Redirect(http.StatusSeeOther, url)
+	c.Abort()
+}
+
+/*authorize - (re)starts authorization process
+for users who have been signed out
+*/
+func authorize(c *gin.Context) {
+	startAuthorization(c, "/user", "/user", c.Query("dialog") == "1")
+}
+
+/*randomString - URL safe random string made of n random bytes
+ */
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+/*pkceChallenge - S256 code challenge for PKCE code verifier
+ */
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+/*localPath - true if endpoint is a path on our site
+(so we don't redirect anywhere we are asked to)
+*/
+func localPath(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "/") && !strings.HasPrefix(endpoint, "//") && !strings.HasPrefix(endpoint, "/\\")
+}
+
 /*clientMagic - is how endpoints obtain Spotify client
 which is from cache (fast and cheap in resources) or by
 retrieving token from Firestore and creating new client (slow)
@@ -115,75 +208,104 @@ func clientMagic(c *gin.Context) *spotify.Client {
 		log.Printf("Couldn't find token for %s", newTok.path)
 		return nil
 	}
-	location, _ := time.LoadLocation("Europe/Warsaw")
+	location := cfg.Location
 	log.Printf("/clientMagic: Token in Firestore expires at: %s", tok.Expiry.In(location).Format("15:04:05"))
-	newClient := auth.NewClient(tok)
+	spotifyClient = newClient(tok, userString, authPath)
 	// if an item doesn't already exist for the given key, or if the existing item has expired
-	kaszka.Add(uuid, &newClient, cache.DefaultExpiration)
-	return &newClient
+	kaszka.Add(uuid, spotifyClient, cache.DefaultExpiration)
+	return spotifyClient
+}
+
+/*missingScopes - required scopes which haven't been granted.
+Tokens saved before we started keeping scopes (nil) are given
+benefit of the doubt.
+*/
+func missingScopes(granted []string) []string {
+	missing := []string{}
+	if granted == nil {
+		return missing
+	}
+	for _, scope := range requiredScopes {
+		found := false
+		for _, g := range granted {
+			if g == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
 }
 
-/*getTokenFromDB - retrieves token fromFirestore
+/*getTokenFromDB - retrieves token from database
  */
 func getTokenFromDB(token *firestoreToken) (*oauth2.Token, error) {
 	// we need Spotify user ID and router group path (auth group) to retrieve a token
-	path := fmt.Sprintf("users/%s/tokens%s", token.user, token.path)
-	dsnap, err := firestoreClient.Doc(path).Get(ctx)
-	if err != nil {
-		log.Printf("Error retrieving token from Firestore for %s %s.\nPossibly it ain't there..", path, err.Error())
-		return nil, err
+	// other instance could have already loaded it
+	stored, found := cachedToken(token.user, "token"+token.path)
+	if !found {
+		var err error
+		stored, err = db.GetToken(token.user, token.path)
+		if err != nil {
+			log.Printf("Error retrieving token for %s %s %s.\nPossibly it ain't there..", token.user, token.path, err.Error())
+			return nil, err
+		}
+		cacheToken(token.user, "token"+token.path, stored, 0)
 	}
-	tok := &oauth2.Token{}
-	dsnap.DataTo(tok)
+	tok := stored.oauth2Token()
 	token.token = tok // here token is set by reference and also returned in input parameter
-	location, _ := time.LoadLocation("Europe/Warsaw")
+	token.scopes = stored.Scopes
+	location := cfg.Location
 	log.Printf("getTokenFromDB: Got token with expiration %s", tok.Expiry.In(location).Format("15:04:05"))
 	return tok, nil
 }
 
-/*saveTokenToDb - saves token to Firestore
+/*saveTokenToDb - saves token to database
  */
 func saveTokenToDB(token *firestoreToken) {
 	// we need Spotify user ID and router group path (auth group) to retrieve a token
-	path := fmt.Sprintf("users/%s/tokens%s", token.user, token.path)
 	// TODO - two set operations - ?
-	_, err := firestoreClient.Doc(path).Set(ctx, token.token)
-	_, err = firestoreClient.Collection("users").Doc(token.user).Set(ctx, map[string]interface{}{
-		"userID":           token.user,
-		"user_displayname": token.displayname,
-		"user_email":       token.email,
-		"country":          token.country,
-		"token_saved":      time.Now(), // account created or logged in new browser
-	}, firestore.MergeAll)
+	err := db.SaveToken(token.user, token.path, newStoredToken(token.token, token.scopes))
+	shared.Delete(token.user, "token"+token.path)
+	if err == nil {
+		err = db.UpdateUser(token.user, map[string]interface{}{
+			"userID":           token.user,
+			"user_displayname": token.displayname,
+			"user_email":       token.email,
+			"country":          token.country,
+			"token_saved":      time.Now(), // account created or logged in new browser
+			"token_status":     tokenValid,
+		})
+		releaseToken(token.user)
+	}
 	if err != nil {
 		log.Printf("saveToken: Error saving token for %s %s", token.path, err.Error())
 	} else {
-		location, _ := time.LoadLocation("Europe/Warsaw")
-		log.Printf("saveToken: Saved token for %s into Firestore", token.path)
+		location := cfg.Location
+		log.Printf("saveToken: Saved token for %s into database", token.path)
 		log.Printf("saveToken: Token expiration %s", token.token.Expiry.In(location).Format("15:04:05"))
 	}
 }
 
-/*updateTokenInDB - updates token in Firestore
+/*updateTokenInDB - updates token in database
  */
 func updateTokenInDB(token *firestoreToken) {
 	// we need Spotify user ID and router group path (auth group) to retrieve a token
-	path := fmt.Sprintf("users/%s/tokens%s", token.user, token.path)
-	_, err := firestoreClient.Doc(path).Set(ctx, map[string]interface{}{
-		"AccessToken":  token.token.AccessToken,
-		"Expiry":       token.token.Expiry,
-		"RefreshToken": token.token.RefreshToken,
-		"TokenType":    token.token.TokenType,
-	}, firestore.MergeAll)
-	_, err = firestoreClient.Collection("users").Doc(token.user).Set(ctx, map[string]interface{}{
-		"token_updated": time.Now(), // last time token had been refreshed
-	}, firestore.MergeAll)
-
+	err := db.SaveToken(token.user, token.path, newStoredToken(token.token, nil)) // keep granted scopes
+	shared.Delete(token.user, "token"+token.path)
+	if err == nil {
+		err = db.UpdateUser(token.user, map[string]interface{}{
+			"token_updated": time.Now(), // last time token had been refreshed
+		})
+	}
 	if err != nil {
-		log.Printf("updateToken: Error saving token for %s %s", path, err.Error())
+		log.Printf("updateToken: Error saving token for %s%s %s", token.user, token.path, err.Error())
 	} else {
-		location, _ := time.LoadLocation("Europe/Warsaw")
-		log.Printf("updateToken: Saved token for %s into Firestore", path)
+		location := cfg.Location
+		log.Printf("updateToken: Saved token for %s%s into database", token.user, token.path)
 		log.Printf("updateToken: Token expiration %s", token.token.Expiry.In(location).Format("15:04:05"))
 	}
 }
@@ -194,9 +316,9 @@ It works for AppEngine, CloudRun/Docker and local testing
 */
 func initFirestoreDatabase(ctx context.Context) *firestore.Client {
 	// Google App Engine
-	if gae != "" {
+	if cfg.GAE != "" {
 		// Not possible locally or on Cloud Run/Docker
-		firestoreClient, err := firestore.NewClient(ctx, os.Getenv("GOOGLE_CLOUD_PROJECT"))
+		firestoreClient, err := firestore.NewClient(ctx, cfg.ProjectID)
 		if err != nil {
 			log.Panic(err)
 		}
@@ -207,8 +329,8 @@ func initFirestoreDatabase(ctx context.Context) *firestore.Client {
 	// https://github.com/googleapis/google-cloud-go/blob/master/firestore/client.go#L62
 	// Read the code and consider that firebase programmers are weird, it's not how it works
 	// in official Google examples for other parts of ecosystem
-	if gcr == "YES" {
-		sa := option.WithCredentialsFile(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")) // this is JSON file path
+	if cfg.GCR == "YES" {
+		sa := option.WithCredentialsFile(cfg.CredentialsFile) // this is JSON file path
 		firestoreClient, err := firestore.NewClient(ctx, "*detect-project-id*", sa)
 		if err != nil {
 			log.Panic(err)
@@ -218,7 +340,7 @@ func initFirestoreDatabase(ctx context.Context) *firestore.Client {
 	}
 	// Default - local testing
 	sa := option.WithCredentialsFile(".firebase-credentials.json")
-	firestoreClient, err := firestore.NewClient(ctx, os.Getenv("GOOGLE_CLOUD_PROJECT"), sa)
+	firestoreClient, err := firestore.NewClient(ctx, cfg.ProjectID, sa)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/redis/go-redis/v9"
+)
+
+/*Cache - data shared by all instances of the app (quarantined tokens,
+sessions signed out, tokens, page data). Every key belongs to a user
+so one user can never read what has been cached for another.
+Values are JSON. Spotify clients can't be shared, they stay in kaszka
+(per instance) and are rebuilt from cached token when missing.
+*/
+type Cache interface {
+	// Get - decodes value cached for user into v, false if not found
+	Get(user string, key string, v interface{}) bool
+	// Set - caches value for user (ttl 0 means cache default)
+	Set(user string, key string, v interface{}, ttl time.Duration)
+	// Add - caches value for user unless key is cached already (false then),
+	// lets one instance claim work for ttl
+	Add(user string, key string, v interface{}, ttl time.Duration) bool
+	// Delete - removes value cached for user
+	Delete(user string, key string)
+	// Close - releases connection
+	Close() error
+}
+
+// keys not belonging to any user yet (login in progress)
+const anonymousUser = "-"
+
+const defaultCacheTTL = 20 * time.Minute
+
+/*initCache - creates Cache selected by CACHE_BACKEND
+(memory by default or redis at REDIS_URL)
+*/
+func initCache() Cache {
+	switch cfg.CacheBackend {
+	case "redis":
+		opts, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			log.Panic(err)
+		}
+		client := redis.NewClient(opts)
+		if err := client.Ping(ctx).Err(); err != nil {
+			log.Panic(err)
+		}
+		log.Printf("REDIS: %s", opts.Addr)
+		return &redisCache{client: client}
+	case "", "memory":
+		return &memoryCache{cache: cache.New(defaultCacheTTL, 3*time.Minute)}
+	default:
+		log.Panicf("Unknown CACHE_BACKEND %s", cfg.CacheBackend)
+	}
+	return nil
+}
+
+/*cacheKey - user namespace is a part of every key
+ */
+func cacheKey(user string, key string) string {
+	if user == "" {
+		user = anonymousUser
+	}
+	return "go-spotify/" + user + "/" + key
+}
+
+/*memoryCache - Cache for single instance
+ */
+type memoryCache struct {
+	cache *cache.Cache
+}
+
+func (m *memoryCache) Get(user string, key string, v interface{}) bool {
+	data, found := m.cache.Get(cacheKey(user, key))
+	if !found {
+		return false
+	}
+	if err := json.Unmarshal(data.([]byte), v); err != nil {
+		log.Printf("Cache: Error decoding %s %s", cacheKey(user, key), err.Error())
+		return false
+	}
+	return true
+}
+
+func (m *memoryCache) Set(user string, key string, v interface{}, ttl time.Duration) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Cache: Error encoding %s %s", cacheKey(user, key), err.Error())
+		return
+	}
+	if ttl == 0 {
+		ttl = cache.DefaultExpiration
+	}
+	m.cache.Set(cacheKey(user, key), data, ttl)
+}
+
+func (m *memoryCache) Add(user string, key string, v interface{}, ttl time.Duration) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Cache: Error encoding %s %s", cacheKey(user, key), err.Error())
+		return false
+	}
+	if ttl == 0 {
+		ttl = cache.DefaultExpiration
+	}
+	return m.cache.Add(cacheKey(user, key), data, ttl) == nil
+}
+
+func (m *memoryCache) Delete(user string, key string) {
+	m.cache.Delete(cacheKey(user, key))
+}
+
+func (m *memoryCache) Close() error {
+	return nil
+}
+
+/*redisCache - Cache shared by all instances (Redis protocol)
+ */
+type redisCache struct {
+	client *redis.Client
+}
+
+func (r *redisCache) Get(user string, key string, v interface{}) bool {
+	data, err := r.client.Get(ctx, cacheKey(user, key)).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Cache: Error getting %s %s", cacheKey(user, key), err.Error())
+		}
+		return false
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		log.Printf("Cache: Error decoding %s %s", cacheKey(user, key), err.Error())
+		return false
+	}
+	return true
+}
+
+func (r *redisCache) Set(user string, key string, v interface{}, ttl time.Duration) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Cache: Error encoding %s %s", cacheKey(user, key), err.Error())
+		return
+	}
+	if ttl == 0 {
+		ttl = defaultCacheTTL
+	}
+	if err := r.client.Set(ctx, cacheKey(user, key), data, ttl).Err(); err != nil {
+		log.Printf("Cache: Error setting %s %s", cacheKey(user, key), err.Error())
+	}
+}
+
+func (r *redisCache) Add(user string, key string, v interface{}, ttl time.Duration) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Cache: Error encoding %s %s", cacheKey(user, key), err.Error())
+		return false
+	}
+	if ttl == 0 {
+		ttl = defaultCacheTTL
+	}
+	added, err := r.client.SetNX(ctx, cacheKey(user, key), data, ttl).Result()
+	if err != nil {
+		log.Printf("Cache: Error adding %s %s", cacheKey(user, key), err.Error())
+		return false
+	}
+	return added
+}
+
+func (r *redisCache) Delete(user string, key string) {
+	if err := r.client.Del(ctx, cacheKey(user, key)).Err(); err != nil {
+		log.Printf("Cache: Error deleting %s %s", cacheKey(user, key), err.Error())
+	}
+}
+
+func (r *redisCache) Close() error {
+	return r.client.Close()
+}
+
+/*cacheToken - keeps token (sealed same way as in database)
+so other instances don't have to load it from database
+*/
+func cacheToken(user string, key string, tok *storedToken, ttl time.Duration) {
+	sealed, err := sealToken(tokenCipher, user, "cache/"+key, tok)
+	if err != nil {
+		log.Printf("cacheToken: %s %s %s", user, key, err.Error())
+		return
+	}
+	shared.Set(user, key, sealed, ttl)
+}
+
+/*cachedToken - token cached by cacheToken
+ */
+func cachedToken(user string, key string) (*storedToken, bool) {
+	var sealed storedToken
+	if !shared.Get(user, key, &sealed) {
+		return nil, false
+	}
+	tok, err := openToken(tokenCipher, user, "cache/"+key, &sealed)
+	if err != nil {
+		log.Printf("cachedToken: %s %s %s", user, key, err.Error())
+		return nil, false
+	}
+	return tok, true
+}
@@ -0,0 +1,3 @@
+module go-spotify/common
+
+go 1.19
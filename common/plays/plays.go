@@ -0,0 +1,151 @@
+/*Package plays - how recently played tracks become plays we keep.
+Shared by the app and CloudRecent function so both ingest alike.
+*/
+package plays
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	Limit    = 50 // most Spotify gives (and remembers)
+	MaxPages = 9  // 450 plays fit in one Firestore batch
+)
+
+/*Item - recently played item as Spotify reports it
+ */
+type Item struct {
+	Name     string
+	Artists  string
+	PlayedAt time.Time
+	ID       string
+	Duration int // ms
+}
+
+/*Play - play as we keep it
+ */
+type Play struct {
+	Item
+	Skipped bool
+}
+
+/*ID - key of a play, played_at (ms, sortable) plus track ID
+so the same track played twice makes two records
+*/
+func ID(playedAt time.Time, trackID string) string {
+	return fmt.Sprintf("%013d_%s", playedAt.UnixNano()/int64(time.Millisecond), trackID)
+}
+
+/*After - all plays after cursor (zero cursor - latest page), paginating
+with "after" until caught up. fetch gets a page of at most Limit plays
+after given time (ms, 0 - latest). Spotify remembers only last fifty plays
+so full first page means we have probably missed some (gap).
+*/
+func After(fetch func(afterMs int64) ([]Item, error), cursor time.Time) ([]Item, bool, error) {
+	items := []Item{}
+	seen := map[string]bool{}
+	gap := false
+	after := cursor
+	for page := 0; page < MaxPages; page++ {
+		var afterMs int64
+		if !after.IsZero() {
+			afterMs = after.UnixNano() / int64(time.Millisecond)
+		}
+		got, err := fetch(afterMs)
+		if err != nil {
+			return items, gap, err
+		}
+		if page == 0 && !cursor.IsZero() && len(got) == Limit {
+			gap = true
+		}
+		fresh := 0
+		newest := after
+		for _, item := range got {
+			key := ID(item.PlayedAt, item.ID)
+			// after has millisecond precision
+			if !item.PlayedAt.After(cursor) || seen[key] {
+				continue
+			}
+			seen[key] = true
+			items = append(items, item)
+			fresh++
+			if item.PlayedAt.After(newest) {
+				newest = item.PlayedAt
+			}
+		}
+		if len(got) < Limit || fresh == 0 {
+			break
+		}
+		after = newest
+	}
+	return items, gap, nil
+}
+
+/*Normalize - turns items into plays we keep, oldest first.
+Spotify history has hiccups due to poor connection and switching between
+players (Chromecast audio) so the same track played again within dedupWindow
+is taken only once. Play is flagged as skipped when the next one has started
+before skipThreshold of its duration has passed.
+last is the newest play kept before (nil if none) - it isn't returned again
+but items repeating it are dropped. The newest play is left out while it may
+still turn out skipped (its next play may start before now reaches
+skipThreshold of it) - it comes again with items after last and is settled
+then. So last should be the newest returned play, not the newest item.
+*/
+func Normalize(last *Item, items []Item, dedupWindow time.Duration, skipThreshold float64, now time.Time) []Play {
+	sorted := make([]Item, len(items))
+	copy(sorted, items)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].PlayedAt.Before(sorted[j].PlayedAt)
+	})
+	plays := []Play{}
+	if last != nil {
+		plays = append(plays, Play{Item: *last})
+	}
+	for _, item := range sorted {
+		if last != nil && !item.PlayedAt.After(last.PlayedAt) {
+			continue
+		}
+		if n := len(plays); n > 0 && dedupWindow > 0 {
+			prev := plays[n-1]
+			if prev.ID == item.ID && item.PlayedAt.Sub(prev.PlayedAt) < dedupWindow {
+				continue
+			}
+		}
+		plays = append(plays, Play{Item: item})
+	}
+	for i := 0; i < len(plays)-1; i++ {
+		plays[i].Skipped = skipped(plays[i], plays[i+1].PlayedAt.Sub(plays[i].PlayedAt), skipThreshold)
+	}
+	if n := len(plays); n > 0 && (last == nil || n > 1) {
+		newest := plays[n-1]
+		if skipped(newest, now.Sub(newest.PlayedAt), skipThreshold) {
+			plays = plays[:n-1] // pending
+		}
+	}
+	if last != nil {
+		plays = plays[1:]
+	}
+	return plays
+}
+
+/*skipped - true if listening for listened is less than skipThreshold of play
+ */
+func skipped(p Play, listened time.Duration, skipThreshold float64) bool {
+	full := time.Duration(p.Duration) * time.Millisecond
+	return full > 0 && listened < time.Duration(skipThreshold*float64(full))
+}
+
+/*Oldest - played_at of the oldest item
+ */
+func Oldest(items []Item) time.Time {
+	var oldest time.Time
+	for _, item := range items {
+		if oldest.IsZero() || item.PlayedAt.Before(oldest) {
+			oldest = item.PlayedAt
+		}
+	}
+	return oldest
+}
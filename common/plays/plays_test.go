@@ -0,0 +1,102 @@
+package plays
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalize(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+	item := func(id string, s int, duration int) Item {
+		return Item{Name: id, ID: id, PlayedAt: at(s), Duration: duration * 1000}
+	}
+	later := at(3600)
+	tests := []struct {
+		name  string
+		last  *Item
+		items []Item
+		now   time.Time
+		want  []string // ID and skipped flag of plays returned
+	}{
+		{
+			name:  "nothing",
+			items: nil,
+			now:   later,
+			want:  []string{},
+		},
+		{
+			name:  "skipped when next one started early",
+			items: []Item{item("b", 60, 200), item("a", 0, 200), item("c", 260, 200)},
+			now:   later,
+			want:  []string{"a skipped", "b", "c"},
+		},
+		{
+			name:  "hiccup within dedup window",
+			items: []Item{item("a", 0, 200), item("a", 10, 200), item("b", 200, 200)},
+			now:   later,
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "the same track again later is another play",
+			items: []Item{item("a", 0, 20), item("a", 40, 20)},
+			now:   later,
+			want:  []string{"a", "a"},
+		},
+		{
+			name:  "newest pending while it may turn out skipped",
+			items: []Item{item("a", 0, 200), item("b", 200, 200)},
+			now:   at(250),
+			want:  []string{"a"},
+		},
+		{
+			name:  "newest kept once it can't be skipped",
+			items: []Item{item("a", 0, 200), item("b", 200, 200)},
+			now:   at(300),
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "newest without duration is never pending",
+			items: []Item{item("a", 0, 0)},
+			now:   at(1),
+			want:  []string{"a"},
+		},
+		{
+			name:  "pending play settled as skipped in the next batch",
+			last:  &Item{ID: "a", PlayedAt: at(0)},
+			items: []Item{item("b", 200, 200), item("c", 230, 200)},
+			now:   later,
+			want:  []string{"b skipped", "c"},
+		},
+		{
+			name:  "dedup spans batches",
+			last:  &Item{ID: "a", PlayedAt: at(0)},
+			items: []Item{item("a", 15, 200), item("b", 200, 200)},
+			now:   later,
+			want:  []string{"b"},
+		},
+		{
+			name:  "last played again is dropped",
+			last:  &Item{ID: "a", PlayedAt: at(0)},
+			items: []Item{item("a", 0, 200)},
+			now:   later,
+			want:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, p := range Normalize(tt.last, tt.items, 30*time.Second, 0.5, tt.now) {
+				if p.Skipped {
+					got = append(got, p.ID+" skipped")
+				} else {
+					got = append(got, p.ID)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Normalize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+/*Package tokenkeys - envelope encryption of tokens kept in database.
+Shared by the app and CloudRecent function so both read and write
+the same records.
+*/
+package tokenkeys
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*Keyring - key encryption keys for tokens kept in database.
+TOKEN_KEYS is a comma separated list of id:key pairs where key is base64
+encoded 32 bytes (openssl rand -base64 32), TOKEN_KEY_ID is id of the key
+used for new records (the only key if there is just one). Old keys stay
+on the list until rotate-keys has re-encrypted tokens with the new one.
+nil Keyring keeps tokens in plaintext.
+*/
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+/*Record - secret part of a token record. When KeyID is set AccessToken
+and RefreshToken are encrypted (base64) with data key which is kept
+encrypted (wrapped) with key KeyID. User and Path tell where record is
+kept and are bound to ciphertext, so record copied elsewhere won't open.
+*/
+type Record struct {
+	User         string
+	Path         string
+	AccessToken  string
+	RefreshToken string
+	KeyID        string
+	WrappedKey   []byte
+}
+
+/*Load - keyring from TOKEN_KEYS and TOKEN_KEY_ID (nil if there are no keys)
+ */
+func Load(spec string, active string) (*Keyring, error) {
+	k := &Keyring{active: active, keys: map[string][]byte{}}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("TOKEN_KEYS: expected id:key, got %q", pair)
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("TOKEN_KEYS: key %s must be 32 bytes in base64", parts[0])
+		}
+		k.keys[parts[0]] = key
+		if active == "" && len(k.keys) == 1 {
+			k.active = parts[0]
+		}
+	}
+	if len(k.keys) == 0 {
+		return nil, nil // tokens are kept in plaintext
+	}
+	if _, ok := k.keys[k.active]; !ok || (active == "" && len(k.keys) > 1) {
+		return nil, fmt.Errorf("TOKEN_KEY_ID: %q is not one of TOKEN_KEYS", active)
+	}
+	return k, nil
+}
+
+/*Active - id of the key new records are sealed with ("" - plaintext)
+ */
+func (k *Keyring) Active() string {
+	if k == nil {
+		return ""
+	}
+	return k.active
+}
+
+/*Seal - envelope encryption. Each record gets its own random data key
+which encrypts AccessToken and RefreshToken and is itself encrypted
+(wrapped) with active key. Key id is stored with the record.
+*/
+func (k *Keyring) Seal(r Record) (Record, error) {
+	sealed := Record{User: r.User, Path: r.Path, AccessToken: r.AccessToken, RefreshToken: r.RefreshToken}
+	if k == nil {
+		return sealed, nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return r, err
+	}
+	wrapped, err := gcmSeal(k.keys[k.active], dataKey, []byte(k.active))
+	if err != nil {
+		return r, err
+	}
+	access, err := gcmSeal(dataKey, []byte(r.AccessToken), r.aad("AccessToken"))
+	if err != nil {
+		return r, err
+	}
+	refresh, err := gcmSeal(dataKey, []byte(r.RefreshToken), r.aad("RefreshToken"))
+	if err != nil {
+		return r, err
+	}
+	sealed.AccessToken = base64.StdEncoding.EncodeToString(access)
+	sealed.RefreshToken = base64.StdEncoding.EncodeToString(refresh)
+	sealed.KeyID = k.active
+	sealed.WrappedKey = wrapped
+	return sealed, nil
+}
+
+/*Open - decrypts record sealed with any of known keys
+(records without key id are plaintext, written before encryption)
+*/
+func (k *Keyring) Open(r Record) (Record, error) {
+	if r.KeyID == "" {
+		return r, nil
+	}
+	if k == nil {
+		return r, fmt.Errorf("token encrypted with key %s but TOKEN_KEYS is not set", r.KeyID)
+	}
+	kek, ok := k.keys[r.KeyID]
+	if !ok {
+		return r, fmt.Errorf("token encrypted with unknown key %s", r.KeyID)
+	}
+	dataKey, err := gcmOpen(kek, r.WrappedKey, []byte(r.KeyID))
+	if err != nil {
+		return r, err
+	}
+	opened := r
+	for _, f := range []struct {
+		name  string
+		value *string
+	}{{"AccessToken", &opened.AccessToken}, {"RefreshToken", &opened.RefreshToken}} {
+		data, err := base64.StdEncoding.DecodeString(*f.value)
+		if err != nil {
+			return r, err
+		}
+		plain, err := gcmOpen(dataKey, data, r.aad(f.name))
+		if err != nil {
+			return r, err
+		}
+		*f.value = string(plain)
+	}
+	return opened, nil
+}
+
+/*aad - additional data field is sealed with: user/path/field
+ */
+func (r Record) aad(field string) []byte {
+	return []byte(r.User + "/" + r.Path + "/" + field)
+}
+
+/*gcmSeal - AES-GCM, random nonce is prepended to ciphertext
+ */
+func gcmSeal(key []byte, plain []byte, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, aad), nil
+}
+
+func gcmOpen(key []byte, data []byte, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("token ciphertext too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], aad)
+}
@@ -0,0 +1,134 @@
+package tokenkeys
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key1 := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("1", 32)))
+	key2 := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("2", 32)))
+	old, err := Load("k1:"+key1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := Load("k1:"+key1+",k2:"+key2, "k2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := Load("k1:"+key2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := Record{User: "user", Path: "tokens/spotify", AccessToken: "access", RefreshToken: "refresh"}
+	tests := []struct {
+		name    string
+		seal    *Keyring
+		open    *Keyring
+		change  func(r *Record)
+		wantErr bool
+	}{
+		{name: "round trip", seal: old, open: old},
+		{name: "plaintext", seal: nil, open: nil},
+		{name: "plaintext opened with keys", seal: nil, open: old},
+		{name: "old key after rotation", seal: old, open: rotated},
+		{name: "new key after rotation", seal: rotated, open: rotated},
+		{name: "new key before rotation", seal: rotated, open: old, wantErr: true},
+		{name: "without keys", seal: old, open: nil, wantErr: true},
+		{name: "wrong key", seal: old, open: other, wantErr: true},
+		{
+			name: "tampered ciphertext",
+			seal: old,
+			open: old,
+			change: func(r *Record) {
+				data, _ := base64.StdEncoding.DecodeString(r.AccessToken)
+				data[len(data)-1] ^= 1
+				r.AccessToken = base64.StdEncoding.EncodeToString(data)
+			},
+			wantErr: true,
+		},
+		{
+			name:    "tampered wrapped key",
+			seal:    old,
+			open:    old,
+			change:  func(r *Record) { r.WrappedKey[len(r.WrappedKey)-1] ^= 1 },
+			wantErr: true,
+		},
+		{
+			name:    "fields swapped",
+			seal:    old,
+			open:    old,
+			change:  func(r *Record) { r.AccessToken, r.RefreshToken = r.RefreshToken, r.AccessToken },
+			wantErr: true,
+		},
+		{
+			name:    "moved to another user",
+			seal:    old,
+			open:    old,
+			change:  func(r *Record) { r.User = "intruder" },
+			wantErr: true,
+		},
+		{
+			name:    "moved to another path",
+			seal:    old,
+			open:    old,
+			change:  func(r *Record) { r.Path = "tokens/other" },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := tt.seal.Seal(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sealed.KeyID != tt.seal.Active() {
+				t.Errorf("Seal() key %q, want %q", sealed.KeyID, tt.seal.Active())
+			}
+			if tt.seal != nil && (sealed.AccessToken == record.AccessToken || sealed.RefreshToken == record.RefreshToken) {
+				t.Errorf("Seal() left token in plaintext")
+			}
+			if tt.change != nil {
+				tt.change(&sealed)
+			}
+			opened, err := tt.open.Open(sealed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (opened.AccessToken != record.AccessToken || opened.RefreshToken != record.RefreshToken) {
+				t.Errorf("Open() = %q %q, want %q %q", opened.AccessToken, opened.RefreshToken, record.AccessToken, record.RefreshToken)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("1", 32)))
+	tests := []struct {
+		name       string
+		spec       string
+		active     string
+		wantActive string
+		wantErr    bool
+	}{
+		{name: "no keys", spec: "", wantActive: ""},
+		{name: "single key is active", spec: "k1:" + key, wantActive: "k1"},
+		{name: "chosen key", spec: "k1:" + key + ", k2:" + key, active: "k2", wantActive: "k2"},
+		{name: "several keys none chosen", spec: "k1:" + key + ",k2:" + key, wantErr: true},
+		{name: "unknown active key", spec: "k1:" + key, active: "k2", wantErr: true},
+		{name: "short key", spec: "k1:" + base64.StdEncoding.EncodeToString([]byte("short")), wantErr: true},
+		{name: "without id", spec: key, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := Load(tt.spec, tt.active)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && k.Active() != tt.wantActive {
+				t.Errorf("Load() active %q, want %q", k.Active(), tt.wantActive)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+/*Config - all settings of the app. They are read from optional
+YAML or TOML file (CONFIG_FILE) and then from environment, so
+environment variables always win over the file.
+*/
+type Config struct {
+	SessionSecret string `yaml:"session_secret" toml:"session_secret" env:"SESSION_SECRET"`
+	// public URL of the app with scheme, used for every redirect
+	// (derived from CUSTOM_DOMAIN or REDIRECT_URI if not set)
+	BaseURL      string `yaml:"base_url" toml:"base_url" env:"BASE_URL"`
+	CustomDomain string `yaml:"custom_domain" toml:"custom_domain" env:"CUSTOM_DOMAIN"`
+	GcrDomain    string `yaml:"gcr_domain" toml:"gcr_domain" env:"GCR_DOMAIN"`
+	RedirectURI  string `yaml:"redirect_uri" toml:"redirect_uri" env:"REDIRECT_URI"`
+	// address server listens on, PORT (set by Cloud Run) if empty
+	ListenAddr string `yaml:"listen_addr" toml:"listen_addr" env:"LISTEN_ADDR"`
+	Port       string `yaml:"port" toml:"port" env:"PORT"`
+	// IANA name, times are shown and playlists named in this timezone
+	Timezone string `yaml:"timezone" toml:"timezone" env:"TIMEZONE"`
+
+	SpotifyID     string `yaml:"spotify_id" toml:"spotify_id" env:"SPOTIFY_ID"`
+	SpotifySecret string `yaml:"spotify_secret" toml:"spotify_secret" env:"SPOTIFY_SECRET"`
+
+	StripeSecretKey      string `yaml:"stripe_secret_key" toml:"stripe_secret_key" env:"STRIPE_SECRET_KEY"`
+	StripePublishableKey string `yaml:"stripe_publishable_key" toml:"stripe_publishable_key" env:"STRIPE_PUBLISHABLE_KEY"`
+	SubscriptionPlanID   string `yaml:"subscription_plan_id" toml:"subscription_plan_id" env:"SUBSCRIPTION_PLAN_ID"`
+
+	ProjectID           string `yaml:"project_id" toml:"project_id" env:"GOOGLE_CLOUD_PROJECT"`
+	CredentialsFile     string `yaml:"credentials_file" toml:"credentials_file" env:"GOOGLE_APPLICATION_CREDENTIALS"`
+	GAE                 string `yaml:"gae_env" toml:"gae_env" env:"GAE_ENV"`
+	GCR                 string `yaml:"google_cloud_run" toml:"google_cloud_run" env:"GOOGLE_CLOUD_RUN"`
+	TimezonesFunction   string `yaml:"timezones_function" toml:"timezones_function" env:"TIMEZONES_CLOUD_FUNCTION"`
+	CloudRecentFunction string `yaml:"cloud_recent_function" toml:"cloud_recent_function" env:"CLOUD_RECENT_FUNCTION"`
+	StoreBackend        string `yaml:"store_backend" toml:"store_backend" env:"STORE_BACKEND"` // firestore (default) or bolt
+	BoltPath            string `yaml:"bolt_path" toml:"bolt_path" env:"BOLT_PATH"`             // bolt database file
+	TokenKeys           string `yaml:"token_keys" toml:"token_keys" env:"TOKEN_KEYS"`          // id:base64key,... for encrypting tokens
+	TokenKeyID          string `yaml:"token_key_id" toml:"token_key_id" env:"TOKEN_KEY_ID"`    // key used for new records
+	CacheBackend        string `yaml:"cache_backend" toml:"cache_backend" env:"CACHE_BACKEND"` // memory (default) or redis
+	RedisURL            string `yaml:"redis_url" toml:"redis_url" env:"REDIS_URL"`             // redis://host:6379/0
+	// recently played tracks are polled in-process every interval (0 or "off" disables)
+	IngestInterval    time.Duration `yaml:"ingest_interval" toml:"ingest_interval" env:"INGEST_INTERVAL"`
+	IngestConcurrency int           `yaml:"ingest_concurrency" toml:"ingest_concurrency" env:"INGEST_CONCURRENCY"`
+	// the same track played again within the window is one play (0 keeps all)
+	DedupWindow time.Duration `yaml:"dedup_window" toml:"dedup_window" env:"INGEST_DEDUP_WINDOW"`
+	// play is a skip if next one started before this share of its duration
+	SkipThreshold float64 `yaml:"skip_threshold" toml:"skip_threshold" env:"SKIP_THRESHOLD"`
+	// stored plays are scrobbled every interval ("off" disables, see scrobble.go)
+	ScrobbleInterval time.Duration `yaml:"scrobble_interval" toml:"scrobble_interval" env:"SCROBBLE_INTERVAL"`
+	// days plays are kept before they are rolled up (user's retention_days wins)
+	RetentionDays        int `yaml:"retention_days" toml:"retention_days" env:"RETENTION_DAYS"`
+	PremiumRetentionDays int `yaml:"premium_retention_days" toml:"premium_retention_days" env:"PREMIUM_RETENTION_DAYS"`
+
+	Location *time.Location `yaml:"-" toml:"-"` // loaded Timezone
+	problems []string       // values which couldn't be parsed (see set)
+}
+
+/*loadConfig - reads CONFIG_FILE (if set) and environment.
+Config isn't validated here - see validate.
+*/
+func loadConfig() *Config {
+	conf := &Config{
+		Timezone:             "Europe/Warsaw",
+		IngestInterval:       0, // off - every instance polling would multiply calls to Spotify
+		IngestConcurrency:    4,
+		ScrobbleInterval:     15 * time.Minute,
+		DedupWindow:          30 * time.Second,
+		SkipThreshold:        0.5,
+		RetentionDays:        7,
+		PremiumRetentionDays: 90,
+	}
+	if file := os.Getenv("CONFIG_FILE"); file != "" {
+		if err := conf.readFile(file); err != nil {
+			log.Panicf("CONFIG_FILE %s: %s", file, err.Error())
+		}
+	}
+	conf.readEnv()
+	if conf.BaseURL == "" {
+		conf.BaseURL = conf.defaultBaseURL()
+	}
+	conf.BaseURL = strings.TrimSuffix(conf.BaseURL, "/")
+	location, err := time.LoadLocation(conf.Timezone)
+	if err != nil {
+		log.Printf("TIMEZONE %s: %s - using UTC", conf.Timezone, err.Error())
+		location = time.UTC
+	}
+	conf.Location = location
+	return conf
+}
+
+/*readFile - YAML (.yaml, .yml) or TOML (.toml) config file. Values
+are parsed the same way as environment variables (see set).
+*/
+func (conf *Config) readFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	values := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("unknown config format %s", filepath.Ext(file))
+	}
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(conf).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		if value, ok := values[t.Field(i).Tag.Get("yaml")]; ok {
+			conf.set(name, v.Field(i), fmt.Sprint(value))
+		}
+	}
+	return nil
+}
+
+/*readEnv - environment variables (named in env tag) override file
+ */
+func (conf *Config) readEnv() {
+	v := reflect.ValueOf(conf).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		if value, ok := os.LookupEnv(name); ok && value != "" {
+			conf.set(name, v.Field(i), value)
+		}
+	}
+}
+
+/*set - parses value into field once, so the rest of the app gets
+durations and numbers. Values which don't parse are kept for validate.
+Duration "off" is 0.
+*/
+func (conf *Config) set(name string, field reflect.Value, value string) {
+	var err error
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case time.Duration:
+		var d time.Duration
+		if value != "off" {
+			d, err = time.ParseDuration(value)
+		}
+		if err == nil {
+			field.SetInt(int64(d))
+		}
+	case int:
+		var n int
+		if n, err = strconv.Atoi(value); err == nil {
+			field.SetInt(int64(n))
+		}
+	case float64:
+		var f float64
+		if f, err = strconv.ParseFloat(value, 64); err == nil {
+			field.SetFloat(f)
+		}
+	}
+	if err != nil {
+		conf.problems = append(conf.problems, fmt.Sprintf("%s %q is not valid", name, value))
+	}
+}
+
+/*defaultBaseURL - custom domain is always served over https,
+otherwise we trust scheme and host of Spotify redirect URI
+*/
+func (conf *Config) defaultBaseURL() string {
+	if conf.CustomDomain != "" {
+		return "https://" + conf.CustomDomain
+	}
+	if u, err := url.Parse(conf.RedirectURI); err == nil && u.Host != "" {
+		return u.Scheme + "://" + u.Host
+	}
+	return ""
+}
+
+/*listenAddr - LISTEN_ADDR, :PORT or :8080
+ */
+func (conf *Config) listenAddr() string {
+	if conf.ListenAddr != "" {
+		return conf.ListenAddr
+	}
+	if conf.Port != "" {
+		return ":" + conf.Port
+	}
+	return ":8080"
+}
+
+/*validate - tells everything that is missing or wrong at once
+ */
+func (conf *Config) validate() error {
+	problems := append([]string{}, conf.problems...)
+	required := []struct{ name, value string }{
+		{"SESSION_SECRET", conf.SessionSecret},
+		{"REDIRECT_URI", conf.RedirectURI},
+		{"SPOTIFY_ID", conf.SpotifyID},
+		{"SPOTIFY_SECRET", conf.SpotifySecret},
+		{"STRIPE_SECRET_KEY", conf.StripeSecretKey},
+		{"STRIPE_PUBLISHABLE_KEY", conf.StripePublishableKey},
+		{"SUBSCRIPTION_PLAN_ID", conf.SubscriptionPlanID},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			problems = append(problems, r.name+" is not set")
+		}
+	}
+	if u, err := url.Parse(conf.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("BASE_URL %q must be absolute http(s) URL", conf.BaseURL))
+	}
+	if conf.RedirectURI != "" && conf.BaseURL != "" && !strings.HasPrefix(conf.RedirectURI, conf.BaseURL+"/") {
+		log.Printf("Config: REDIRECT_URI %s is outside BASE_URL %s", conf.RedirectURI, conf.BaseURL)
+	}
+	if conf.Location.String() != conf.Timezone {
+		problems = append(problems, fmt.Sprintf("TIMEZONE %q is not a known timezone", conf.Timezone))
+	}
+	if conf.IngestInterval != 0 && conf.IngestInterval < time.Minute {
+		problems = append(problems, fmt.Sprintf("INGEST_INTERVAL %s must be off or at least 1m", conf.IngestInterval))
+	}
+	if conf.ScrobbleInterval != 0 && conf.ScrobbleInterval < time.Minute {
+		problems = append(problems, fmt.Sprintf("SCROBBLE_INTERVAL %s must be off or at least 1m", conf.ScrobbleInterval))
+	}
+	if conf.IngestConcurrency < 1 {
+		problems = append(problems, fmt.Sprintf("INGEST_CONCURRENCY %d must be positive number", conf.IngestConcurrency))
+	}
+	if conf.DedupWindow < 0 {
+		problems = append(problems, fmt.Sprintf("INGEST_DEDUP_WINDOW %s must not be negative", conf.DedupWindow))
+	}
+	if conf.SkipThreshold < 0 || conf.SkipThreshold > 1 {
+		problems = append(problems, fmt.Sprintf("SKIP_THRESHOLD %g must be number between 0 and 1", conf.SkipThreshold))
+	}
+	for _, r := range []struct {
+		name  string
+		value int
+	}{
+		{"RETENTION_DAYS", conf.RetentionDays},
+		{"PREMIUM_RETENTION_DAYS", conf.PremiumRetentionDays},
+	} {
+		if r.value < 1 {
+			problems = append(problems, fmt.Sprintf("%s %d must be positive number of days", r.name, r.value))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("config: " + strings.Join(problems, ", "))
+	}
+	return nil
+}
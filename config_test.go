@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfigSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   interface{} // pointer to field of such type
+		value   string
+		want    interface{}
+		problem bool
+	}{
+		{"string", new(string), "x", "x", false},
+		{"duration", new(time.Duration), "30m", 30 * time.Minute, false},
+		{"duration off", new(time.Duration), "off", time.Duration(0), false},
+		{"duration typo", new(time.Duration), "30mins", time.Duration(0), true},
+		{"number", new(int), "4", 4, false},
+		{"number typo", new(int), "four", 0, true},
+		{"float", new(float64), "0.5", 0.5, false},
+		{"float typo", new(float64), "half", 0.0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Config{}
+			field := reflect.ValueOf(tt.field).Elem()
+			conf.set("NAME", field, tt.value)
+			if got := field.Interface(); got != tt.want {
+				t.Errorf("set() = %v, want %v", got, tt.want)
+			}
+			if (len(conf.problems) > 0) != tt.problem {
+				t.Errorf("set() problems = %v", conf.problems)
+			}
+		})
+	}
+}
+
+func TestConfigReadFile(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{"yaml", "config.yaml", "session_secret: secret\nredirect_uri: https://example.com/callback\n"},
+		{"toml", "config.toml", "session_secret = \"secret\"\nredirect_uri = \"https://example.com/callback\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(file, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			conf := &Config{}
+			if err := conf.readFile(file); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("SESSION_SECRET", "from env")
+			conf.readEnv()
+			if conf.SessionSecret != "from env" || conf.RedirectURI != "https://example.com/callback" || len(conf.problems) > 0 {
+				t.Errorf("readFile() = %+v", conf)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	exportChunk        = 100     // plays looked up for features at once
+	parquetRowGroupMax = 4 << 20 // bytes of rows kept before writing row group
+)
+
+/*exportRow - exported play with audio features if we have them
+ */
+type exportRow struct {
+	PlayedAt time.Time      `json:"played_at"`
+	TrackID  string         `json:"track_id"`
+	Name     string         `json:"track_name"`
+	Artists  string         `json:"artists"`
+	Duration int            `json:"duration_ms,omitempty"`
+	Skipped  bool           `json:"skipped"`
+	Features *trackFeatures `json:"audio_features,omitempty"`
+}
+
+// parquetRow - exportRow flattened for Parquet, missing features are null
+type parquetRow struct {
+	PlayedAt         int64    `parquet:"name=played_at, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	TrackID          string   `parquet:"name=track_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Name             string   `parquet:"name=track_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Artists          string   `parquet:"name=artists, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Duration         int32    `parquet:"name=duration_ms, type=INT32"`
+	Skipped          bool     `parquet:"name=skipped, type=BOOLEAN"`
+	Acousticness     *float64 `parquet:"name=acousticness, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Danceability     *float64 `parquet:"name=danceability, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Energy           *float64 `parquet:"name=energy, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Instrumentalness *float64 `parquet:"name=instrumentalness, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Liveness         *float64 `parquet:"name=liveness, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Loudness         *float64 `parquet:"name=loudness, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Speechiness      *float64 `parquet:"name=speechiness, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Tempo            *float64 `parquet:"name=tempo, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Valence          *float64 `parquet:"name=valence, type=DOUBLE, repetitiontype=OPTIONAL"`
+}
+
+// header and order of CSV columns
+var exportColumns = []string{"played_at", "track_id", "track_name", "artists", "duration_ms", "skipped",
+	"acousticness", "danceability", "energy", "instrumentalness", "liveness", "loudness", "speechiness", "tempo", "valence"}
+
+/*rowWriter - writes exported plays in one of formats
+ */
+type rowWriter interface {
+	Write(row exportRow) error
+	Close() error
+}
+
+/*exportHistory - streams user's plays as CSV (default), JSON Lines
+or Parquet file. from and to (YYYY-MM-DD, both inclusive, in user's timezone)
+limit date range. Long histories take longer than server's WriteTimeout
+so there is no write deadline here.
+/history/export?format=jsonl&from=2020-01-01&to=2020-01-31[&tz=Europe/Warsaw]
+*/
+func exportHistory(c *gin.Context) {
+	user, _ := sessions.Default(c).Get("user").(string)
+	location := userTimezone(user, c.Query("tz"))
+	from, err := exportDate(c.Query("from"), location)
+	if err != nil {
+		c.String(http.StatusBadRequest, "from: %s", err.Error())
+		return
+	}
+	to, err := exportDate(c.Query("to"), location)
+	if err != nil {
+		c.String(http.StatusBadRequest, "to: %s", err.Error())
+		return
+	}
+	if !to.IsZero() {
+		to = to.AddDate(0, 0, 1) // whole last day
+	}
+	format := c.DefaultQuery("format", "csv")
+	var contentType string
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+	case "jsonl":
+		contentType = "application/x-ndjson"
+	case "parquet":
+		contentType = "application/vnd.apache.parquet"
+	default:
+		c.String(http.StatusBadRequest, "Unknown format %s (csv, jsonl or parquet)", format)
+		return
+	}
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("/history/export: %s", err.Error())
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="history-%s.%s"`, user, format))
+	w, err := newRowWriter(format, c.Writer)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	chunk := []firestoreTrack{}
+	flush := func() error {
+		ids := []string{}
+		for _, tr := range chunk {
+			ids = append(ids, tr.ID)
+		}
+		features, err := db.TrackFeatures(ids)
+		if err != nil {
+			return err
+		}
+		for _, tr := range chunk {
+			row := exportRow{
+				PlayedAt: tr.PlayedAt,
+				TrackID:  tr.ID,
+				Name:     tr.Name,
+				Artists:  tr.Artists,
+				Duration: tr.Duration,
+				Skipped:  tr.Skipped,
+			}
+			if f, ok := features[tr.ID]; ok {
+				row.Features = &f
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+		chunk = chunk[:0]
+		return nil
+	}
+	err = db.EachPlay(user, from, to, func(tr firestoreTrack) error {
+		chunk = append(chunk, tr)
+		if len(chunk) < exportChunk {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// headers are gone already, broken file is all we can do
+		c.Error(err)
+	}
+}
+
+/*exportDate - YYYY-MM-DD in location, zero time if empty
+ */
+func exportDate(s string, location *time.Location) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, location)
+}
+
+func newRowWriter(format string, w io.Writer) (rowWriter, error) {
+	switch format {
+	case "jsonl":
+		return &jsonlWriter{enc: json.NewEncoder(w)}, nil
+	case "parquet":
+		pw, err := writer.NewParquetWriterFromWriter(w, new(parquetRow), 1)
+		if err != nil {
+			return nil, err
+		}
+		pw.RowGroupSize = parquetRowGroupMax
+		pw.CompressionType = parquet.CompressionCodec_SNAPPY
+		return &parquetWriter{pw: pw}, nil
+	}
+	cw := csv.NewWriter(w)
+	return &csvWriter{w: cw}, cw.Write(exportColumns)
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (cw *csvWriter) Write(row exportRow) error {
+	record := []string{
+		row.PlayedAt.UTC().Format(time.RFC3339),
+		row.TrackID,
+		row.Name,
+		row.Artists,
+		strconv.Itoa(row.Duration),
+		strconv.FormatBool(row.Skipped),
+	}
+	if f := row.Features; f != nil {
+		for _, v := range []float64{f.Acousticness, f.Danceability, f.Energy, f.Instrumentalness, f.Liveness, f.Loudness, f.Speechiness, f.Tempo, f.Valence} {
+			record = append(record, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	} else {
+		record = append(record, make([]string, len(exportColumns)-len(record))...)
+	}
+	return cw.w.Write(record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (jw *jsonlWriter) Write(row exportRow) error {
+	return jw.enc.Encode(row)
+}
+
+func (jw *jsonlWriter) Close() error {
+	return nil
+}
+
+type parquetWriter struct {
+	pw *writer.ParquetWriter
+}
+
+func (pw *parquetWriter) Write(row exportRow) error {
+	r := parquetRow{
+		PlayedAt: row.PlayedAt.UnixNano() / int64(time.Millisecond),
+		TrackID:  row.TrackID,
+		Name:     row.Name,
+		Artists:  row.Artists,
+		Duration: int32(row.Duration),
+		Skipped:  row.Skipped,
+	}
+	if f := row.Features; f != nil {
+		r.Acousticness, r.Danceability, r.Energy = &f.Acousticness, &f.Danceability, &f.Energy
+		r.Instrumentalness, r.Liveness, r.Loudness = &f.Instrumentalness, &f.Liveness, &f.Loudness
+		r.Speechiness, r.Tempo, r.Valence = &f.Speechiness, &f.Tempo, &f.Valence
+	}
+	return pw.pw.Write(r)
+}
+
+func (pw *parquetWriter) Close() error {
+	return pw.pw.WriteStop()
+}
@@ -1,20 +1,24 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
-	"cloud.google.com/go/firestore"
 	spotify "github.com/chew-z/spotify"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	guuid "github.com/google/uuid"
 	"github.com/patrickmn/go-cache"
-	"google.golang.org/api/iterator"
+	"golang.org/x/oauth2"
 )
 
 const (
@@ -27,103 +31,168 @@ const (
 )
 
 var (
-	kaszka = cache.New(20*time.Minute, 3*time.Minute)
-	// Warning token will fail if you are changing scope (even if you narrow it down) so you might end up with bunch
-	// of useless stored tokens that will keep failing
-	// TODO - procedure for clearing useless token (users will have to re-authorize with Spotify)
-	auth = spotify.NewAuthenticator(redirectURI, spotify.ScopeUserReadEmail, spotify.ScopeUserReadPrivate, spotify.ScopeUserTopRead, spotify.ScopeUserLibraryRead, spotify.ScopeUserFollowRead, spotify.ScopeUserReadRecentlyPlayed, spotify.ScopePlaylistModifyPublic, spotify.ScopePlaylistModifyPrivate, spotify.ScopePlaylistReadCollaborative, spotify.ScopePlaylistReadPrivate) // clientChannel = make(chan *spotify.Client)
+	kaszka = cache.New(20*time.Minute, 3*time.Minute) // Spotify clients of this instance by session uuid
+	// Scopes the running build requires. Granted scopes are stored with each token
+	// and users whose tokens lack any of these are asked to re-authorize
+	// (MidnightRun keeps a copy of this list - keep them in sync)
+	requiredScopes = []string{spotify.ScopeUserReadEmail, spotify.ScopeUserReadPrivate, spotify.ScopeUserTopRead, spotify.ScopeUserLibraryRead, spotify.ScopeUserFollowRead, spotify.ScopeUserReadRecentlyPlayed, spotify.ScopePlaylistModifyPublic, spotify.ScopePlaylistModifyPrivate, spotify.ScopePlaylistReadCollaborative, spotify.ScopePlaylistReadPrivate}
+	auth           = spotify.NewAuthenticator(cfg.RedirectURI, requiredScopes...) // clientChannel = make(chan *spotify.Client)
+	// Authenticator keeps oauth2 config to itself and we need it
+	// for PKCE (extra parameters in auth URL and token exchange)
+	oauthConfig = &oauth2.Config{
+		ClientID:     cfg.SpotifyID,
+		ClientSecret: cfg.SpotifySecret,
+		RedirectURL:  cfg.RedirectURI,
+		Scopes:       requiredScopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  spotify.AuthURL,
+			TokenURL: spotify.TokenURL,
+		},
+	}
+	// same transport as Authenticator uses (HTTP/2 disabled, see zmb3/spotify#20)
+	spotifyTransport = &http.Transport{TLSNextProto: map[string]func(string, *tls.Conn) http.RoundTripper{}}
+	oauthContext     = context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: spotifyTransport})
 )
 
-/* statefull authorization handler using channels
-state = calling endpoint (which is intended use of scope)
-caches client for as long as token is valid (3 hour for spotify)
-no persistent storing of token, there is no need?
-spotify stores persisten cookies behind our back so it is enough?
+/* callback - Spotify redirects here after user has authorized the app.
+State must be the one we have put into this browser's session and
+code is exchanged for token together with PKCE code verifier.
+Client is handed over to /login by one-time id bound to the session.
 */
 func callback(c *gin.Context) {
-	endpoint := c.Request.FormValue("state")
-	log.Printf("/callback: endpoint: %s", endpoint)
-	tok, err := auth.Token(endpoint, c.Request)
+	session := sessions.Default(c)
+	state, _ := session.Get("oauthState").(string)
+	verifier, _ := session.Get("oauthVerifier").(string)
+	// state and verifier are good for one attempt only
+	session.Delete("oauthState")
+	session.Delete("oauthVerifier")
+	if e := c.Query("error"); e != "" {
+		session.Save()
+		authFailed(c, fmt.Sprintf("Spotify says: %s", e))
+		return
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+		session.Save()
+		authFailed(c, "Authorization state doesn't match the one we have started in this browser.")
+		return
+	}
+	tok, err := oauthConfig.Exchange(oauthContext, c.Query("code"),
+		oauth2.SetAuthURLParam("code_verifier", verifier))
 	if err != nil {
-		c.String(http.StatusForbidden, "Couldn't get token")
+		log.Printf("/callback: %s", err.Error())
+		session.Save()
+		authFailed(c, "Couldn't get token from Spotify.")
+		return
+	}
+	log.Println("/callback: Login Completed!")
+	// This is just a trick for passing token further (to /login endpoint) to get and save Spotify userID
+	// (/login could be served by another instance so token goes through shared cache)
+	handoff := randomString(16)
+	cacheToken(anonymousUser, "handoff/"+handoff, newStoredToken(tok, grantedScopes(tok)), 2*time.Minute)
+	session.Set("handoff", handoff)
+	if err := session.Save(); err != nil {
 		log.Panic(err)
 	}
-	uuid := guuid.New().String()
-	go func() {
-		spotifyClient := auth.NewClient(tok)
-		log.Println("/callback: Login Completed!")
-		// This is just a trick for passing client further (to /login endpoint) to get and save Spotify userID
-		kaszka.Set(uuid, &spotifyClient, cache.DefaultExpiration)
-		log.Printf("/callback: Cached client for: %s", endpoint)
-		// clientChannel <- &spotifyClient
-	}()
-	url := fmt.Sprintf("http://%s%s?endpoint=%s&id=%s", customDomain, "/login", endpoint, uuid)
-	defer func() {
-		log.Printf("callback: redirecting to endpoint %s", url)
-		c.Redirect(http.StatusFound, url)
-	}()
+	url := fmt.Sprintf("%s%s?id=%s", cfg.BaseURL, "/login", handoff)
+	log.Printf("callback: redirecting to endpoint %s", url)
+	c.Redirect(http.StatusFound, url)
 }
 
 /* login - This endpoint completes authorization process
 it takes over from /callback, gets Spotify user, saves session variables
-and saves token into database. Finaly it redirects to /user
-TODO - separate final endpoint from authPath parameter
+and saves token into database. Finaly it redirects to endpoint user
+has been heading to when authorization started.
+Handoff id can be used only once and only in the browser which started
+authorization.
 TODO - analyze what if it fails, is canceled? and we are left with token
 but not session vars or session vars but no token saved?
 */
 func login(c *gin.Context) {
-	// This is where to we shall redirect after finishing login process
-	// but also session authPath variable - endpoints for which user
-	// is authorized
-	// default is "/user"
-	endpoint := c.Query("endpoint")
-	uuid := c.Query("id") // create session unique id
-	// /callback should have stored Spotify client in cache
-	if gclient, foundClient := kaszka.Get(uuid); foundClient {
-		log.Printf("/login: Cached client found for: %s", uuid)
-		// get Spotify client
-		spotifyClient := gclient.(*spotify.Client)
-		// and get Spotify user (user.ID)
-		user, err := spotifyClient.CurrentUser()
-		if err != nil {
-			log.Panic(err)
-		}
-		// get token for client
-		newToken, _ := spotifyClient.Token()
-		log.Println(newToken.Expiry.Sub(time.Now()))
-		// path := fmt.Sprintf("users/%s/tokens%s", string(user.ID), endpoint)
-		// save token to Firestore
-		var newTok firestoreToken
-		newTok.user = string(user.ID)
-		newTok.displayname = user.DisplayName
-		newTok.email = user.Email
-		newTok.country = string(user.Country)
-		newTok.path = endpoint
-		newTok.token = newToken
-		saveTokenToDB(&newTok)
-		//Initialize history (don't wait) (must have token saved into firestore)
-		go cloudRecent(string(user.ID))
-		// save necessary variables into session
-		session := sessions.Default(c)
-		// TODO - is it necessary and what would be optimal?
-
-		sessions.Default(c).Options(sessions.Options{MaxAge: sessionTimeout}) // make a session timeout after X seconds of inactivity
-		log.Printf("/login: %s from %s", string(user.ID), string(user.Country))
-		session.Set("user", string(user.ID))
-		session.Set("email", user.Email)
-		session.Set("displayname", user.DisplayName)
-		session.Set("country", string(user.Country))
-		session.Set("authPath", endpoint)
-		session.Set("uuid", uuid)
-		if err := session.Save(); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"login": "failed to set session values"})
-			return
-		}
-		url := fmt.Sprintf("http://%s%s", c.Request.Host, endpoint)
-		c.Redirect(http.StatusSeeOther, url)
+	session := sessions.Default(c)
+	handoff := c.Query("id")
+	expected, _ := session.Get("handoff").(string)
+	session.Delete("handoff")
+	if handoff == "" || subtle.ConstantTimeCompare([]byte(handoff), []byte(expected)) != 1 {
+		session.Save()
+		authFailed(c, "This login link doesn't belong to this browser or has already been used.")
+		return
+	}
+	// /callback should have stored Spotify token in cache
+	handoffToken, foundToken := cachedToken(anonymousUser, "handoff/"+handoff)
+	shared.Delete(anonymousUser, "handoff/"+handoff)
+	if !foundToken {
+		session.Save()
+		authFailed(c, "Login took too long or has already been completed.")
 		return
 	}
-	c.JSON(http.StatusTeapot, gin.H{"login": "failed to find cached client"})
+	// authPath - endpoints for which user is authorized (default is "/user")
+	// and endpoint - this is where to we shall redirect after finishing login process
+	authPath, _ := session.Get("oauthPath").(string)
+	endpoint, _ := session.Get("oauthEndpoint").(string)
+	if authPath == "" {
+		authPath = "/user"
+	}
+	if !localPath(endpoint) {
+		endpoint = authPath
+	}
+	session.Delete("oauthPath")
+	session.Delete("oauthEndpoint")
+	log.Printf("/login: Cached token found for: %s", handoff)
+	// get Spotify client
+	client := auth.NewClient(handoffToken.oauth2Token())
+	spotifyClient := &client
+	// and get Spotify user (user.ID)
+	user, err := spotifyClient.CurrentUser()
+	if err != nil {
+		log.Panic(err)
+	}
+	// get token for client
+	newToken, _ := spotifyClient.Token()
+	log.Println(newToken.Expiry.Sub(time.Now()))
+	// save token to database
+	var newTok firestoreToken
+	newTok.user = string(user.ID)
+	newTok.displayname = user.DisplayName
+	newTok.email = user.Email
+	newTok.country = string(user.Country)
+	newTok.path = authPath
+	newTok.token = newToken
+	newTok.scopes = handoffToken.Scopes
+	saveTokenToDB(&newTok)
+	// from now on client is persisting refreshed tokens
+	spotifyClient = newClient(newToken, newTok.user, newTok.path)
+	//Initialize history (don't wait) (must have token saved into firestore)
+	app.Go(func(ctx context.Context) { cloudRecent(ctx, string(user.ID)) })
+	// save necessary variables into session
+	// TODO - is it necessary and what would be optimal?
+	session.Options(sessions.Options{MaxAge: sessionTimeout}) // make a session timeout after X seconds of inactivity
+	log.Printf("/login: %s from %s", string(user.ID), string(user.Country))
+	uuid := guuid.New().String() // create session unique id
+	session.Set("user", string(user.ID))
+	session.Set("email", user.Email)
+	session.Set("displayname", user.DisplayName)
+	session.Set("country", string(user.Country))
+	session.Set("authPath", authPath)
+	session.Set("uuid", uuid)
+	kaszka.Set(uuid, spotifyClient, cache.DefaultExpiration)
+	recordSession(c, string(user.ID), uuid)
+	if err := session.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"login": "failed to set session values"})
+		return
+	}
+	url := fmt.Sprintf("%s%s", cfg.BaseURL, endpoint)
+	c.Redirect(http.StatusSeeOther, url)
+}
+
+/* authFailed - explains why we have rejected authorization attempt
+ */
+func authFailed(c *gin.Context, reason string) {
+	log.Printf("authFailed: %s", reason)
+	c.HTML(http.StatusForbidden, "authfailed.html", gin.H{
+		"title":  "Authorization failed",
+		"reason": reason,
+	})
+	c.Abort()
 }
 
 /*logout - simplistic logout
@@ -136,11 +205,36 @@ session vars, otherwise we have Panic on casting string on nil interface
 func logout(c *gin.Context) {
 	// without clearing Spotify cookie we will be simply re-logged transparently
 	session := sessions.Default(c)
+	user, _ := session.Get("user").(string)
+	current, _ := session.Get("uuid").(string)
+	// plain /logout link signs out this browser only, other devices
+	// and tokens only through forms on /user page (see SameOrigin)
+	target, everywhere, forget := current, false, false
+	if c.Request.Method == http.MethodPost {
+		if s := c.PostForm("session"); s != "" {
+			target = s
+		}
+		everywhere = c.PostForm("everywhere") == "1"
+		forget = c.PostForm("forget") == "1"
+	}
+	if forget { // without token no session is of any use
+		forgetTokens(user)
+		everywhere = true
+	}
+	if everywhere {
+		signOutEverywhere(user)
+	} else {
+		signOutSession(user, target)
+	}
+	if !everywhere && target != current { // other device signed out - back to the list
+		c.Redirect(http.StatusSeeOther, "/user")
+		return
+	}
 	session.Clear() // issue #91
 	session.Save()
 	log.Printf("/logout: %s", "bye")
-	url := fmt.Sprintf("http://%s%s", c.Request.Host, "/")
-	c.Redirect(305, url)
+	url := fmt.Sprintf("%s%s", cfg.BaseURL, "/")
+	c.Redirect(http.StatusSeeOther, url)
 }
 
 /* top - prints user's top tracks (sensible defaults)
@@ -197,25 +291,21 @@ func popular(c *gin.Context) {
 			}
 			user = string(u.ID)
 		}
-		path := fmt.Sprintf("users/%s/popular_tracks", user)
-		pops := firestoreClient.Collection(path).OrderBy("count", firestore.Desc).Limit(pageLimit).Documents(ctx)
-		var pt popularTrack
+		window := c.DefaultQuery("range", "all")
+		if _, ok := popularWindows[window]; !ok {
+			window = "all"
+		}
+		pops, err := db.PopularTracks(user.(string), window, pageLimit)
+		if err != nil {
+			log.Println(err.Error())
+		}
 		var toplist []int
+		var skipRates []int
 		trackIDs := []spotify.ID{}
-		for {
-			doc, err := pops.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				log.Println(err.Error())
-			}
-			trackID := spotify.ID(doc.Ref.ID)
-			trackIDs = append(trackIDs, trackID)
-			if err := doc.DataTo(&pt); err != nil {
-				log.Println(err.Error())
-			}
-			toplist = append(toplist, pt.Count)
+		for _, pt := range pops {
+			trackIDs = append(trackIDs, spotify.ID(pt.ID))
+			toplist = append(toplist, pt.windowCount(window))
+			skipRates = append(skipRates, int(math.Round(100*pt.skipRate())))
 		}
 		topTracks, err := fullTrackGetMany(spotifyClient, trackIDs)
 		if err != nil {
@@ -225,6 +315,7 @@ func popular(c *gin.Context) {
 		var tracks []topTrack
 		for i := range toplist {
 			tt.Count = toplist[i]
+			tt.SkipRate = skipRates[i]
 			tt.Name = topTracks[i].Name
 			tt.Artists = joinArtists(topTracks[i].Artists, ", ")
 			tt.URL = topTracks[i].ExternalURLs["spotify"]
@@ -237,6 +328,7 @@ func popular(c *gin.Context) {
 			"popular.html",
 			gin.H{
 				"Tracks": tracks,
+				"Range":  window,
 				"title":  "Popular tracks",
 			},
 		)
@@ -267,27 +359,18 @@ func history(c *gin.Context) {
 			}
 			user = string(u.ID)
 		}
-		path := fmt.Sprintf("users/%s/recently_played", user)
-		q := paginateHistory(page, path, c)
-		docs, err := q.Documents(ctx).GetAll()
+		tracks, err := db.RecentlyPlayed(user.(string), paginateHistory(page, c))
 		if err != nil {
 			log.Println(err.Error())
 		}
-		lastDoc := docs[len(docs)-1].Data()["played_at"].(time.Time)
-		c.SetCookie("lastDoc", lastDoc.String(), 1200, endpoint, "", false, true)
+		if len(tracks) > 0 {
+			lastDoc := tracks[len(tracks)-1].PlayedAt
+			c.SetCookie("lastDoc", lastDoc.String(), 1200, endpoint, "", false, true)
+		}
 		c.SetCookie("lastPage", page, 1200, endpoint, "", false, true)
-		var tr firestoreTrack
-		var tracks []firestoreTrack
-		if len(docs) < pageLimit {
+		if len(tracks) < pageLimit {
 			nav.Next = ""
 		}
-		for _, doc := range docs {
-			if err := doc.DataTo(&tr); err != nil {
-				log.Println(err.Error())
-			} else {
-				tracks = append(tracks, tr)
-			}
-		}
 		nav.Endpoint = endpoint
 		c.HTML(
 			http.StatusOK,
@@ -305,46 +388,47 @@ func history(c *gin.Context) {
 (recently played tracks) [Firestore version]
 recommeded tracks could replace default mood playlist
 or any other (based on passed parameters)
-r=3 - replace, p=[ID]
+r=1&list=[ID] - save list user has been shown as new playlist
+when=now - recommends for current time slot (user's timezone as tz)
 TODO - defaultMoodPlaylistID - create new or store default in DB for user
 */
 func moodFromHistory(c *gin.Context) {
 	endpoint := c.Request.URL.Path
 	spotifyClient := clientMagic(c)
 	var recommendedTracks []spotify.FullTrack
-	var err error
 	if spotifyClient != nil {
+		userID := sessions.Default(c).Get("user").(string)
+		opts, err := userMoodOptions(userID, c)
+		if err != nil {
+			log.Printf("%s: %s", endpoint, err.Error())
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		list := c.Query("list")
+		slot := ""
 		if replace := c.Query("r"); replace == "1" { // if Save button
-			if y, found := kaszka.Get("tracks_" + endpoint); found { // get from cache
-				log.Printf("tracks_%s found", endpoint)
-				recommendedTracks = y.([]spotify.FullTrack)
-			} else { // or generate new recommendations if cache empty
-				recommendedTracks, err = recommendFromHistory(spotifyClient, c)
-				if err != nil {
-					log.Println(err.Error())
-					c.String(http.StatusNotFound, err.Error())
-				}
+			// save exactly the list user has been shown (and only user's own list)
+			if !shared.Get(userID, "mood/"+list, &recommendedTracks) {
+				log.Printf("mood/%s not found for %s", list, userID)
+				c.String(http.StatusGone, "This mood list has expired. Go back to /mood for a new one.")
+				return
 			}
+			shared.Delete(userID, "mood/"+list) // saved once
 			// get track IDs for created playlist
 			chunks := chunkIDs(getSpotifyIDs(recommendedTracks), pageLimit)
 			// and do the hops to create playlist and save tracks
-			user, err := spotifyClient.CurrentUser()
-			if err == nil {
-				log.Printf("User found %s", user.DisplayName)
-			} else {
-				log.Println(err.Error())
-			}
-			location, _ := time.LoadLocation("Europe/Warsaw") // TODO
+			location := cfg.Location
 			playlist, err := spotifyClient.CreatePlaylistForUser(
-				user.ID,
+				userID,
 				fmt.Sprintf("Mood %s", time.Now().In(location).Format("Monday Jan _2 15:04")),
 				"Generated by music.suka.yoga",
 				false)
-			if err == nil {
-				log.Printf("Playlist created %s", playlist.ID.String())
-			} else {
+			if err != nil {
 				log.Println(err.Error())
+				c.String(http.StatusNotFound, err.Error())
+				return
 			}
+			log.Printf("Playlist created %s", playlist.ID.String())
 			recommendedPlaylistID := spotify.ID(playlist.SimplePlaylist.ID)
 			for _, chunk := range chunks {
 				err = spotifyClient.ReplacePlaylistTracks(recommendedPlaylistID, chunk...)
@@ -354,15 +438,23 @@ func moodFromHistory(c *gin.Context) {
 					log.Println(err.Error())
 				}
 			}
+			list = ""
 		} else {
 			// get recommendation (no saving)
-			recommendedTracks, err = recommendFromHistory(spotifyClient, c)
+			if c.Query("when") == "now" { // for this time of day (see profiles.go)
+				recommendedTracks, slot, err = recommendFromProfile(spotifyClient, c, opts)
+			} else {
+				recommendedTracks, err = recommendFromHistory(spotifyClient, c, opts)
+			}
 			if err != nil {
 				log.Println(err.Error())
 				c.String(http.StatusNotFound, err.Error())
+				return
 			}
+			// remember what user has been shown for Save button
+			list = randomString(8)
+			shared.Set(userID, "mood/"+list, recommendedTracks, 0)
 		}
-		kaszka.SetDefault("tracks_"+endpoint, recommendedTracks)
 		// display tracks
 		var tt topTrack
 		var tracks []topTrack
@@ -378,8 +470,14 @@ func moodFromHistory(c *gin.Context) {
 			http.StatusOK,
 			"mood.html",
 			gin.H{
-				"Tracks": tracks,
-				"title":  "Mood",
+				"Tracks":     tracks,
+				"List":       list,
+				"Options":    opts,
+				"Slot":       slot,
+				"Attributes": moodForm(opts),
+				"Windows":    []string{"short", "medium", "long"},
+				"SeedFrom":   moodSeedChoices,
+				"title":      "Mood",
 			},
 		)
 		return
@@ -392,6 +490,7 @@ func moodFromHistory(c *gin.Context) {
 func user(c *gin.Context) {
 	spotifyClient := clientMagic(c)
 	var User userLocation
+	var stored firestoreUser
 	if spotifyClient != nil {
 		user, err := spotifyClient.CurrentUser()
 		if err != nil {
@@ -400,21 +499,23 @@ func user(c *gin.Context) {
 		User.Country = user.Country
 		User.Name = user.DisplayName
 		User.URL = user.ExternalURLs["spotify"]
-		dsnap, err := firestoreClient.Collection("users").Doc(user.ID).Get(ctx)
+		u, err := db.GetUser(user.ID)
 		if err != nil {
-			log.Printf("Error retrieving token from Firestore for %s %s.\nPossibly it ain't there..", user.ID, err.Error())
-		}
-		p := dsnap.Data()["premium_user"]
-		if p == nil {
-			User.Premium = false
+			log.Printf("Error retrieving user %s %s.\nPossibly it ain't there..", user.ID, err.Error())
 		} else {
-			User.Premium = p.(bool)
+			User.Premium = u.Premium
+			stored = *u
 		}
+		retention := gin.H{"Days": stored.RetentionDays, "Plan": planRetentionDays(stored, cfg)}
+		current, _ := sessions.Default(c).Get("uuid").(string)
 		c.HTML(
 			http.StatusOK,
 			"user.html",
 			gin.H{
-				"User": User,
+				"User":      User,
+				"Sessions":  userSessions(user.ID, current),
+				"Scrobble":  stored,
+				"Retention": retention,
 			},
 		)
 		return
@@ -422,6 +523,99 @@ func user(c *gin.Context) {
 	c.String(http.StatusTeapot, "I am a teapot, that's all I know")
 }
 
+/*importPage - upload form and progress of imports (see importer.go)
+ */
+func importPage(c *gin.Context) {
+	user, _ := sessions.Default(c).Get("user").(string)
+	var files []importProgress
+	shared.Get(user, "import", &files)
+	c.HTML(
+		http.StatusOK,
+		"import.html",
+		gin.H{
+			"Files":   files,
+			"Running": importRunning(files),
+			"title":   "Import",
+		},
+	)
+}
+
+/*importUpload - takes history files (Spotify, Last.fm or ListenBrainz export)
+and imports them one by one in background. Progress is kept in shared cache
+so /import can be reloaded on any instance.
+*/
+func importUpload(c *gin.Context) {
+	endpoint := c.Request.URL.Path
+	spotifyClient := clientMagic(c)
+	if spotifyClient == nil {
+		c.JSON(http.StatusTeapot, gin.H{endpoint: "failed to find Spotify client"})
+		return
+	}
+	user, _ := sessions.Default(c).Get("user").(string)
+	var files []importProgress
+	if shared.Get(user, "import", &files) && importRunning(files) {
+		c.String(http.StatusConflict, "Import is already running")
+		return
+	}
+	rc := http.NewResponseController(c.Writer)
+	if err := rc.SetReadDeadline(time.Now().Add(importUploadTimeout)); err != nil {
+		log.Printf("/import: %s", err.Error())
+	}
+	if err := rc.SetWriteDeadline(time.Now().Add(importUploadTimeout)); err != nil {
+		log.Printf("/import: %s", err.Error())
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+	form, err := c.MultipartForm()
+	if err != nil {
+		log.Printf("/import: %s", err.Error())
+		c.String(http.StatusBadRequest, fmt.Sprintf("Upload at most %d MB at once", maxImportSize>>20))
+		return
+	}
+	names := []string{}
+	uploads := [][]byte{}
+	for _, fh := range form.File["history"] {
+		f, err := fh.Open()
+		if err != nil {
+			log.Printf("/import: %s", err.Error())
+			continue
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			log.Printf("/import: %s", err.Error())
+			continue
+		}
+		names = append(names, fh.Filename)
+		uploads = append(uploads, data)
+	}
+	if len(uploads) == 0 {
+		c.String(http.StatusBadRequest, "No history file")
+		return
+	}
+	files = make([]importProgress, len(names))
+	for i, name := range names {
+		files[i].File = name
+		files[i].Updated = time.Now()
+	}
+	shared.Set(user, "import", files, 24*time.Hour)
+	app.Go(func(ctx context.Context) {
+		for i := range uploads {
+			progress, err := importHistory(ctx, spotifyClient, user, names[i], uploads[i], func(p importProgress) {
+				files[i] = p
+				shared.Set(user, "import", files, 24*time.Hour)
+			})
+			if err != nil {
+				log.Printf("/import: %s %s %s", user, names[i], err.Error())
+				progress.Error = err.Error()
+				progress.Updated = time.Now()
+			}
+			files[i] = progress
+			shared.Set(user, "import", files, 24*time.Hour)
+		}
+	})
+	c.Redirect(http.StatusSeeOther, "/import")
+}
+
 /*chart - present audio features for tracks from history/album/playlist
 as radar or pie. History tracks are taken form firestore rather
 then directly from spotify history.
@@ -503,17 +697,16 @@ func chart(c *gin.Context) {
 				}
 				user = string(u.ID)
 			}
-			path := fmt.Sprintf("users/%s/recently_played", user)
-			q := paginateHistory(page, path, c)
-			docs, err := q.Documents(ctx).GetAll()
+			tracks, err := db.RecentlyPlayed(user.(string), paginateHistory(page, c))
 			if err != nil {
 				log.Println(err.Error())
 			}
-			lastDoc := docs[len(docs)-1].Data()["played_at"].(time.Time)
-			c.SetCookie("lastDoc", lastDoc.String(), 1200, endpoint, "", false, true)
-			for _, doc := range docs {
-				trackID := spotify.ID(doc.Ref.ID)
-				trackIDs = append(trackIDs, trackID)
+			if len(tracks) > 0 {
+				lastDoc := tracks[len(tracks)-1].PlayedAt
+				c.SetCookie("lastDoc", lastDoc.String(), 1200, endpoint, "", false, true)
+			}
+			for _, tr := range tracks {
+				trackIDs = append(trackIDs, spotify.ID(tr.ID))
 			}
 			nav.Back = "/history"
 		}
@@ -877,6 +1070,12 @@ func recommend(c *gin.Context) {
 			c.String(http.StatusNotFound, err.Error())
 			return
 		}
+		// fresh=1 - only tracks user doesn't know (artists=1 - nor their artists)
+		var known *knownTracks
+		if c.Query("fresh") == "1" {
+			user, _ := sessions.Default(c).Get("user").(string)
+			known = knownTracksFor(client, user)
+		}
 		var b strings.Builder
 		b.WriteString("Recommended tracks based on following tracks\n")
 		seedTracks, errFull := fullTrackGetMany(client, trackIDs)
@@ -890,6 +1089,9 @@ func recommend(c *gin.Context) {
 		}
 		b.WriteString("---/---\n")
 		for _, item := range recs.Tracks {
+			if known.excludes(item.ID, item.Artists, c.Query("artists") == "1") {
+				continue
+			}
 			b.WriteString(fmt.Sprintf("  %s - %s : %s\n", item.ID, item.Name, joinArtists(item.Artists, ", ")))
 		}
 		c.String(http.StatusOK, b.String())
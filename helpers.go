@@ -7,66 +7,55 @@ import (
 	"strconv"
 	"strings"
 
-	"cloud.google.com/go/firestore"
 	spotify "github.com/chew-z/spotify"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
-	"google.golang.org/api/iterator"
 )
 
-/* recommendFromHistory - (like recommendFromMood but latest tracks
-are taken from Firebase store (unique tracks etc.)
-TODO - add limit as parameter (short, medium, long)
+/* recommendFromHistory - suggest new music based on latest tracks
+taken from Firebase store (unique tracks etc.)
+History window, seeds, number of tracks and attributes are set
+by user (see mood.go)
 */
-func recommendFromHistory(spotifyClient *spotify.Client, c *gin.Context) ([]spotify.FullTrack, error) {
+func recommendFromHistory(spotifyClient *spotify.Client, c *gin.Context, opts moodOptions) ([]spotify.FullTrack, error) {
 	recommendedTracks := []spotify.FullTrack{}
 	recentTracksIDs := []spotify.ID{}
 	session := sessions.Default(c)
 	user := session.Get("user").(string)
 	country := session.Get("country").(string)
-	//  get latest [pageLimit] tracks from firestore
-	path := fmt.Sprintf("users/%s/recently_played", user)
-	iter := firestoreClient.Collection(path).OrderBy("played_at", firestore.Desc).Limit(pageLimit).Documents(ctx)
-	// var tr firestoreTrack
-	// fiil in recentTracksIDs
-	defer iter.Stop()
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		// log.Println(doc.Ref.ID) get TrackIDs, must be after iterator.Done otherwise we hit nil pointer
-		if err != nil {
-			log.Println(err.Error())
-			return recommendedTracks, err
+	//  get latest tracks of history window from database
+	recent, err := db.RecentlyPlayed(user, historyQuery{Limit: moodWindows[opts.Window]})
+	if err != nil {
+		log.Println(err.Error())
+		return recommendedTracks, err
+	}
+	// fiil in recentTracksIDs (unique)
+	for _, tr := range recent {
+		if tr.ID != "" {
+			recentTracksIDs = appendIfUnique(recentTracksIDs, spotify.ID(tr.ID))
 		}
-		recentTracksIDs = append(recentTracksIDs, spotify.ID(doc.Ref.ID)) // TODO == *tr.ID
-		// if err := doc.DataTo(&tr); err != nil {
-		// 	log.Println(err.Error())
-		// 	return recommendedTracks, err
-		// }
 	}
-	if recentTracksIDs == nil {
+	if len(recentTracksIDs) == 0 {
 		return recommendedTracks, errors.New("History seems empty")
 	}
-	// get full tracks for track IDs
-	recentTracks, err := fullTrackGetMany(spotifyClient, recentTracksIDs)
-	if err != nil {
-		return recommendedTracks, err
-	}
 	// get attributes for tracks
-	trackAttributes, err := getTrackAttributes(spotifyClient, recentTracks)
+	features, err := moodFeatures(spotifyClient, recentTracksIDs)
 	if err != nil {
 		return recommendedTracks, err
 	}
-	// modern tracks not oldies, seed by recent 5 tracks and average attributes of retrieved tracks (pageLimit)
+	// seed by tracks chosen from history and average attributes of history window
 	params := recommendationParameters{
-		FromYear:      1999,
-		MinTrackCount: 20,
+		FromYear:      opts.FromYear,
+		ToYear:        opts.ToYear,
+		MinTrackCount: opts.Count,
 		Seeds: spotify.Seeds{
-			Tracks: recentTracksIDs[0:4],
+			Tracks: seedTracks(recent, opts.Seeds, opts.SeedFrom),
 		},
-		TrackAttributes: trackAttributes,
+		TrackAttributes: moodTrackAttributes(features, opts),
+	}
+	if opts.Fresh {
+		params.Known = knownTracksFor(spotifyClient, user)
+		params.KnownArtists = opts.FreshArtists
 	}
 	// get recommendations
 	pageTracks, err := getRecommendedTracks(spotifyClient, params, &country)
@@ -79,111 +68,6 @@ func recommendFromHistory(spotifyClient *spotify.Client, c *gin.Context) ([]spot
 	return pageTracks, nil
 }
 
-/* recommendFromMood - suggest new music based on recently playing tracks
-5 recent tracks and averaged attibutes of of recent tracks.
-It works well.
-TODO - add parametrization
-*/
-func recommendFromMood(spotifyClient *spotify.Client) ([]spotify.FullTrack, error) {
-	recommendedTracks := []spotify.FullTrack{}
-	recentTracksIDs := []spotify.ID{}
-	// get recently played tracks
-	recentlyPlayed, err := spotifyClient.PlayerRecentlyPlayed()
-	if err != nil {
-		return recommendedTracks, fmt.Errorf("Failed to get user's recently played: %v", err)
-	}
-	// but only unique no hiccups
-	for _, item := range recentlyPlayed {
-		recentTracksIDs = appendIfUnique(recentTracksIDs, item.Track.ID)
-	}
-	// get full tracks
-	recentTracks, err := fullTrackGetMany(spotifyClient, recentTracksIDs)
-	if err != nil {
-		return recommendedTracks, fmt.Errorf("Failed to full tracks: %v", err)
-	}
-	// get averaged attributes
-	trackAttributes, err := getTrackAttributes(spotifyClient, recentTracks)
-	if err != nil {
-		return recommendedTracks, err
-	}
-	// seed with recent five tracks and attributes of recently played
-	params := recommendationParameters{
-		FromYear:      1999,
-		MinTrackCount: 20,
-		Seeds: spotify.Seeds{
-			Tracks: recentTracksIDs[0:4],
-		},
-		TrackAttributes: trackAttributes,
-	}
-	user, err := spotifyClient.CurrentUser() //TODO - all this isn't necessary
-	if err != nil {
-		return recommendedTracks, fmt.Errorf("Failed to get user: %v", err)
-	}
-	country := string(user.Country)
-	// get recommendation
-	pageTracks, err := getRecommendedTracks(spotifyClient, params, &country)
-	if err != nil {
-		return recommendedTracks, fmt.Errorf("Failed to get recommended tracks: %v", err)
-	}
-	// TODO This is not necessary as we are not looping
-	// recommendedTracks = append(recommendedTracks, pageTracks...)
-
-	return pageTracks, nil
-}
-
-/* recommendFromTop - recommend music based on your top artists and
-averaged attributes of user's top tracks
-TODO - this doesn't make sense like getting country tracks for Miles Davis
-*/
-func recommendFromTop(spotifyClient *spotify.Client) ([]spotify.FullTrack, error) {
-	tracks := []spotify.FullTrack{}
-	limit := 5
-	// Get top five artists
-	userTopArtists, err := spotifyClient.CurrentUsersTopArtistsOpt(&spotify.Options{Limit: &limit})
-	if err != nil {
-		return tracks, fmt.Errorf("Failed to get user's top artists: %v", err)
-	}
-	// get top tracks
-	userTopTracks, err := spotifyClient.CurrentUsersTopTracks()
-	if err != nil {
-		return tracks, fmt.Errorf("Failed to get user's top tracks: %v", err)
-	}
-	// get averaged attributes (audio features) for top tracks
-	trackAttributes, err := getTrackAttributes(spotifyClient, userTopTracks.Tracks)
-	if err != nil {
-		return tracks, err
-	}
-	user, err := spotifyClient.CurrentUser() //TODO - all this isn't necessary
-	if err != nil {
-		return tracks, fmt.Errorf("Failed to get user: %v", err)
-	}
-	country := string(user.Country)
-	// Loop over top artists and get recommendations
-	// This doesn't make any sense to me with diverse tastes
-	for _, artist := range userTopArtists.Artists {
-		log.Printf("Fetching recommendations seeded by artist %s", artist.Name)
-
-		params := recommendationParameters{
-			FromYear:      1999,
-			MinTrackCount: pageLimit,
-			Seeds: spotify.Seeds{
-				Artists: []spotify.ID{artist.ID},
-			},
-			TrackAttributes: trackAttributes,
-		}
-		pageTracks, err := getRecommendedTracks(spotifyClient, params, &country)
-		if err != nil {
-			return tracks, err
-		}
-
-		log.Printf("Fetched %d recommendations seeded by artist %s", len(pageTracks), artist.Name)
-
-		tracks = append(tracks, pageTracks...)
-	}
-
-	return tracks, nil
-}
-
 /* miniAudioFeatures - quickly implemented function to feed charts
 gets selected audio features for songs, normalizes them (TODO - think through)
 and packs and returns
@@ -196,6 +80,7 @@ func miniAudioFeatures(ids []spotify.ID, spotifyClient *spotify.Client) *[]audio
 	if err != nil {
 		log.Println(err.Error())
 	}
+	keepFeatures(audioFeatures)
 	fullTracks, err := fullTrackGetMany(spotifyClient, chunks[0])
 	if err != nil {
 		log.Println(err.Error())
@@ -219,6 +104,40 @@ func miniAudioFeatures(ids []spotify.ID, spotifyClient *spotify.Client) *[]audio
 	return &audioTracks
 }
 
+/*keepFeatures - saves audio features we have got from Spotify
+so history can be exported (and analysed) with them
+*/
+func keepFeatures(features []*spotify.AudioFeatures) {
+	keep := []trackFeatures{}
+	for _, f := range features {
+		if f == nil {
+			continue
+		}
+		keep = append(keep, newTrackFeatures(f))
+	}
+	if len(keep) == 0 {
+		return
+	}
+	if err := db.SaveTrackFeatures(keep); err != nil {
+		log.Printf("keepFeatures: %s", err.Error())
+	}
+}
+
+func newTrackFeatures(f *spotify.AudioFeatures) trackFeatures {
+	return trackFeatures{
+		ID:               string(f.ID),
+		Acousticness:     float64(f.Acousticness),
+		Danceability:     float64(f.Danceability),
+		Energy:           float64(f.Energy),
+		Instrumentalness: float64(f.Instrumentalness),
+		Liveness:         float64(f.Liveness),
+		Loudness:         float64(f.Loudness),
+		Speechiness:      float64(f.Speechiness),
+		Tempo:            float64(f.Tempo),
+		Valence:          float64(f.Valence),
+	}
+}
+
 /* getTrackAttributes - return averaged audio features for set of tracks
  */
 func getTrackAttributes(spotifyClient *spotify.Client, tracks []spotify.FullTrack) (*spotify.TrackAttributes, error) {
@@ -232,6 +151,7 @@ func getTrackAttributes(spotifyClient *spotify.Client, tracks []spotify.FullTrac
 			err,
 		)
 	}
+	keepFeatures(features)
 
 	acousticness := []float64{}
 	instrumentalness := []float64{}
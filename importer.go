@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	spotify "github.com/chew-z/spotify"
+)
+
+// history file formats we can import
+const (
+	formatSpotify      = "spotify"      // Spotify (extended) streaming history JSON
+	formatLastfm       = "lastfm"       // Last.fm scrobbles CSV
+	formatListenBrainz = "listenbrainz" // ListenBrainz listens JSON (or JSON lines)
+)
+
+const (
+	// play of the same track that close to one we already have is the same play
+	// (sources don't agree on played_at to the millisecond)
+	importMatchWindow = 5 * time.Minute
+	maxImportSize     = 64 << 20 // bytes uploaded at once
+	importBatch       = 500      // plays saved at once (and progress reported)
+	// uploading files takes longer than server's ReadTimeout allows
+	importUploadTimeout = 5 * time.Minute
+)
+
+var errAlreadyImported = errors.New("import: this file has been imported already")
+
+/*importedPlay - play read from history file. ID is empty if file
+has only names, Listened is zero if file doesn't tell.
+*/
+type importedPlay struct {
+	firestoreTrack
+	Listened time.Duration
+}
+
+/*importProgress - what import is doing, kept in shared cache
+under user's "import" key so any instance can show it
+*/
+type importProgress struct {
+	File       string    `json:"file"`
+	Format     string    `json:"format"`
+	Read       int       `json:"read"`       // plays in file
+	Resolved   int       `json:"resolved"`   // track IDs found by search
+	Unresolved int       `json:"unresolved"` // dropped, no such track on Spotify
+	Duplicates int       `json:"duplicates"` // already in history
+	Saved      int       `json:"saved"`
+	Done       bool      `json:"done"`
+	Error      string    `json:"error,omitempty"`
+	Updated    time.Time `json:"updated"`
+}
+
+/*importRunning - true if some file is still being imported (progress
+has been reported lately, import dies with instance which runs it)
+*/
+func importRunning(files []importProgress) bool {
+	for _, f := range files {
+		if !f.Done && f.Error == "" && time.Since(f.Updated) < 10*time.Minute {
+			return true
+		}
+	}
+	return false
+}
+
+/*detectFormat - format by file name and first bytes of it
+ */
+func detectFormat(name string, head []byte) (string, error) {
+	if strings.EqualFold(filepath.Ext(name), ".csv") {
+		return formatLastfm, nil
+	}
+	switch {
+	case bytes.Contains(head, []byte(`"ms_played"`)), bytes.Contains(head, []byte(`"msPlayed"`)):
+		return formatSpotify, nil
+	case bytes.Contains(head, []byte(`"listened_at"`)):
+		return formatListenBrainz, nil
+	}
+	return "", fmt.Errorf("import: unknown format of %s", name)
+}
+
+/*parseHistory - reads plays from history file
+ */
+func parseHistory(format string, data []byte) ([]importedPlay, error) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff")) // BOM
+	switch format {
+	case formatSpotify:
+		return parseSpotifyHistory(data)
+	case formatLastfm:
+		return parseLastfmHistory(data)
+	case formatListenBrainz:
+		return parseListenBrainzHistory(data)
+	}
+	return nil, fmt.Errorf("import: unknown format %s", format)
+}
+
+/*parseSpotifyHistory - endsong/Streaming_History_Audio_*.json from extended
+streaming history or StreamingHistory*.json from account data (names only).
+Both tell when play has ended and how long it lasted, so play has started
+that long before (plays are stored by start). Podcasts are left out.
+*/
+func parseSpotifyHistory(data []byte) ([]importedPlay, error) {
+	var items []struct {
+		// extended streaming history
+		TS       string `json:"ts"`
+		MsPlayed int64  `json:"ms_played"`
+		Track    string `json:"master_metadata_track_name"`
+		Artist   string `json:"master_metadata_album_artist_name"`
+		URI      string `json:"spotify_track_uri"`
+		Skipped  *bool  `json:"skipped"`
+		// account data
+		EndTime    string `json:"endTime"`
+		MsPlayed2  int64  `json:"msPlayed"`
+		TrackName  string `json:"trackName"`
+		ArtistName string `json:"artistName"`
+	}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	plays := []importedPlay{}
+	for _, item := range items {
+		var p importedPlay
+		var err error
+		if item.TS != "" {
+			p.PlayedAt, err = time.Parse(time.RFC3339, item.TS)
+			p.Name, p.Artists = item.Track, item.Artist
+			p.ID = strings.TrimPrefix(item.URI, "spotify:track:")
+			p.Listened = time.Duration(item.MsPlayed) * time.Millisecond
+			p.Skipped = item.Skipped != nil && *item.Skipped
+		} else {
+			p.PlayedAt, err = time.Parse("2006-01-02 15:04", item.EndTime)
+			p.Name, p.Artists = item.TrackName, item.ArtistName
+			p.Listened = time.Duration(item.MsPlayed2) * time.Millisecond
+		}
+		if err != nil || p.Name == "" { // podcast episode or broken record
+			continue
+		}
+		p.PlayedAt = p.PlayedAt.Add(-p.Listened)
+		plays = append(plays, p)
+	}
+	return plays, nil
+}
+
+/*parseLastfmHistory - CSV with uts,utc_time,artist,artist_mbid,album,album_mbid,track,track_mbid
+header or without header as artist,album,track,date (lastfm-to-csv)
+*/
+func parseLastfmHistory(data []byte) ([]importedPlay, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	plays := []importedPlay{}
+	columns := map[string]int{}
+	for line := 0; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return plays, err
+		}
+		if line == 0 && len(record) > 0 && record[0] == "uts" {
+			for i, name := range record {
+				columns[name] = i
+			}
+			continue
+		}
+		var p importedPlay
+		if len(columns) > 0 {
+			get := func(name string) string {
+				if i, ok := columns[name]; ok && i < len(record) {
+					return record[i]
+				}
+				return ""
+			}
+			uts, err := strconv.ParseInt(get("uts"), 10, 64)
+			if err != nil {
+				continue
+			}
+			p.PlayedAt = time.Unix(uts, 0).UTC()
+			p.Name, p.Artists = get("track"), get("artist")
+		} else {
+			if len(record) < 4 {
+				continue
+			}
+			if p.PlayedAt, err = time.Parse("02 Jan 2006 15:04", record[3]); err != nil {
+				continue // now playing has no date
+			}
+			p.Name, p.Artists = record[2], record[0]
+		}
+		if p.Name != "" {
+			plays = append(plays, p)
+		}
+	}
+	return plays, nil
+}
+
+/*parseListenBrainzHistory - JSON array of listens or one listen per line
+ */
+func parseListenBrainzHistory(data []byte) ([]importedPlay, error) {
+	type listen struct {
+		ListenedAt int64 `json:"listened_at"`
+		Metadata   struct {
+			Artist string `json:"artist_name"`
+			Track  string `json:"track_name"`
+			Info   struct {
+				SpotifyID  string `json:"spotify_id"` // https://open.spotify.com/track/ID
+				DurationMs int    `json:"duration_ms"`
+			} `json:"additional_info"`
+		} `json:"track_metadata"`
+	}
+	var listens []listen
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := json.Unmarshal(data, &listens); err != nil {
+			return nil, err
+		}
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var l listen
+			if err := dec.Decode(&l); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			listens = append(listens, l)
+		}
+	}
+	plays := []importedPlay{}
+	for _, l := range listens {
+		if l.Metadata.Track == "" || l.ListenedAt == 0 {
+			continue
+		}
+		var p importedPlay
+		p.PlayedAt = time.Unix(l.ListenedAt, 0).UTC()
+		p.Name, p.Artists = l.Metadata.Track, l.Metadata.Artist
+		if id := l.Metadata.Info.SpotifyID; id != "" {
+			p.ID = id[strings.LastIndex(id, "/")+1:]
+		}
+		p.Duration = l.Metadata.Info.DurationMs
+		plays = append(plays, p)
+	}
+	return plays, nil
+}
+
+/*importHistory - imports history file into user's plays. Track IDs
+missing in file are searched for on Spotify, plays already in history
+(the same track within importMatchWindow) are left out. The same file
+(by content) is imported only once as older plays end up in rollups
+where duplicates couldn't be found. report is called as import goes.
+*/
+func importHistory(ctx context.Context, spotifyClient *spotify.Client, user string, name string, data []byte, report func(importProgress)) (importProgress, error) {
+	progress := importProgress{File: filepath.Base(name)}
+	update := func() {
+		progress.Updated = time.Now()
+		report(progress)
+	}
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	store := db.WithContext(ctx)
+	u, err := store.GetUser(user)
+	if err != nil && err != errNotFound {
+		return progress, err
+	}
+	if u != nil {
+		for _, imported := range u.ImportedFiles {
+			if imported == digest {
+				return progress, errAlreadyImported
+			}
+		}
+	}
+	head := data
+	if len(head) > 4096 {
+		head = head[:4096]
+	}
+	if progress.Format, err = detectFormat(name, head); err != nil {
+		return progress, err
+	}
+	plays, err := parseHistory(progress.Format, data)
+	if err != nil {
+		return progress, fmt.Errorf("import: reading %s %w", progress.File, err)
+	}
+	progress.Read = len(plays)
+	update()
+	if err := resolveTracks(ctx, spotifyClient, plays, &progress, update); err != nil {
+		return progress, err
+	}
+	tracks, err := newPlays(store, user, plays, &progress)
+	if err != nil {
+		return progress, err
+	}
+	update()
+	for start := 0; start < len(tracks); start += importBatch {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+		end := start + importBatch
+		if end > len(tracks) {
+			end = len(tracks)
+		}
+		if err := store.SaveRecentlyPlayed(user, tracks[start:end]); err != nil {
+			return progress, err
+		}
+		progress.Saved = end
+		update()
+	}
+	imported := []string{digest}
+	if u != nil {
+		imported = append(u.ImportedFiles, digest)
+	}
+	if err := store.UpdateUser(user, map[string]interface{}{"imported_files": imported}); err != nil {
+		log.Printf("importHistory: Error saving imported files of %s %s", user, err.Error())
+	}
+	progress.Done = true
+	update()
+	return progress, nil
+}
+
+/*resolveTracks - finds track IDs (searching by track and artist name,
+each pair once) and durations (needed for skip detection) on Spotify.
+Plays which can't be found are left without ID.
+*/
+func resolveTracks(ctx context.Context, spotifyClient *spotify.Client, plays []importedPlay, progress *importProgress, update func()) error {
+	found := map[string]spotify.ID{}
+	limit := 1
+	for i := range plays {
+		if plays[i].ID != "" {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		key := strings.ToLower(plays[i].Artists + "\x00" + plays[i].Name)
+		id, ok := found[key]
+		if !ok {
+			query := fmt.Sprintf("track:%q artist:%q", plays[i].Name, plays[i].Artists)
+			result, err := spotifyClient.SearchOpt(query, spotify.SearchTypeTrack, &spotify.Options{Limit: &limit})
+			if err != nil {
+				log.Printf("resolveTracks: %s %s", query, err.Error())
+			} else if result.Tracks != nil && len(result.Tracks.Tracks) > 0 {
+				id = result.Tracks.Tracks[0].ID
+			}
+			found[key] = id
+			if len(found)%100 == 0 {
+				update()
+			}
+		}
+		if id != "" {
+			plays[i].ID = string(id)
+			progress.Resolved++
+		}
+	}
+	// durations of tracks not known from file
+	ids := []spotify.ID{}
+	for _, p := range plays {
+		if p.ID != "" && p.Duration == 0 {
+			ids = appendIfUnique(ids, spotify.ID(p.ID))
+		}
+	}
+	durations := map[string]int{}
+	for _, chunk := range chunkIDs(ids, 50) { // most Spotify gives at once
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		tracks, err := spotifyClient.GetTracks(chunk...)
+		if err != nil {
+			log.Printf("resolveTracks: Failed to get many tracks: %s", err.Error())
+			continue
+		}
+		for _, track := range tracks {
+			if track != nil {
+				durations[string(track.ID)] = track.Duration
+			}
+		}
+	}
+	for i := range plays {
+		if plays[i].Duration == 0 {
+			plays[i].Duration = durations[plays[i].ID]
+		}
+	}
+	return nil
+}
+
+/*newPlays - plays with track ID which aren't in history yet, oldest first.
+Only stored plays around the file's time span are read. A play matches a stored
+one of the same track within importMatchWindow, plays in the file only match
+each other exactly (the same track at the same time) - replaying a track
+right away is another play.
+When file tells how long track has been played we know if it was skipped.
+*/
+func newPlays(store Store, user string, plays []importedPlay, progress *importProgress) ([]firestoreTrack, error) {
+	sort.Slice(plays, func(i, j int) bool {
+		return plays[i].PlayedAt.Before(plays[j].PlayedAt)
+	})
+	var first, last time.Time
+	for _, p := range plays {
+		if p.ID == "" {
+			continue
+		}
+		if first.IsZero() {
+			first = p.PlayedAt
+		}
+		last = p.PlayedAt
+	}
+	stored := map[string][]time.Time{}
+	if !first.IsZero() {
+		err := store.EachPlay(user, first.Add(-importMatchWindow), last.Add(importMatchWindow), func(tr firestoreTrack) error {
+			stored[tr.ID] = append(stored[tr.ID], tr.PlayedAt)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	threshold := cfg.SkipThreshold
+	inFile := map[string]bool{}
+	tracks := []firestoreTrack{}
+	for _, p := range plays {
+		if p.ID == "" {
+			progress.Unresolved++
+			continue
+		}
+		key := playID(p.firestoreTrack)
+		if inFile[key] || playedNear(stored[p.ID], p.PlayedAt) {
+			progress.Duplicates++
+			continue
+		}
+		inFile[key] = true
+		full := time.Duration(p.Duration) * time.Millisecond
+		if p.Listened > 0 && full > 0 && p.Listened < time.Duration(threshold*float64(full)) {
+			p.Skipped = true
+		}
+		tracks = append(tracks, p.firestoreTrack)
+	}
+	return tracks, nil
+}
+
+/*playedNear - true if any of times is within importMatchWindow of t
+ */
+func playedNear(times []time.Time, t time.Time) bool {
+	for _, other := range times {
+		if d := t.Sub(other); d < importMatchWindow && d > -importMatchWindow {
+			return true
+		}
+	}
+	return false
+}
+
+/*importCommand - go-spotify import <user> <file>... imports history
+files into user's plays (user has to log in first so we have a token)
+*/
+func importCommand(store *encryptedStore) error {
+	if len(os.Args) < 4 {
+		return errors.New("usage: go-spotify import <Spotify user ID> <file>...")
+	}
+	user := os.Args[2]
+	tok, err := getTokenFromDB(&firestoreToken{user: user, path: "/user"})
+	if err != nil {
+		return err
+	}
+	spotifyClient := newClient(tok, user, "/user")
+	for _, name := range os.Args[3:] {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		last := time.Now()
+		progress, err := importHistory(ctx, spotifyClient, user, name, data, func(p importProgress) {
+			if time.Since(last) > 5*time.Second || p.Done {
+				log.Printf("import: %s %+v", name, p)
+				last = time.Now()
+			}
+		})
+		if err == errAlreadyImported {
+			log.Printf("import: %s has been imported already", name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("import: %s - %d plays read, %d saved, %d duplicates, %d not found on Spotify",
+			name, progress.Read, progress.Saved, progress.Duplicates, progress.Unresolved)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlayedNear(t *testing.T) {
+	t0 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		times []time.Time
+		t     time.Time
+		want  bool
+	}{
+		{"none", nil, t0, false},
+		{"same time", []time.Time{t0}, t0, true},
+		{"just before window", []time.Time{t0}, t0.Add(importMatchWindow - time.Second), true},
+		{"window apart", []time.Time{t0}, t0.Add(importMatchWindow), false},
+		{"earlier within window", []time.Time{t0}, t0.Add(-time.Minute), true},
+		{"any of times", []time.Time{t0.Add(-time.Hour), t0.Add(time.Minute)}, t0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := playedNear(tt.times, tt.t); got != tt.want {
+				t.Errorf("playedNear() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSpotifyHistory(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []importedPlay
+	}{
+		{
+			name: "extended history",
+			data: `[{"ts": "2024-03-01T12:03:20Z", "ms_played": 200000, "master_metadata_track_name": "Song",
+				"master_metadata_album_artist_name": "Band", "spotify_track_uri": "spotify:track:abc", "skipped": true}]`,
+			want: []importedPlay{{
+				firestoreTrack: firestoreTrack{Name: "Song", Artists: "Band", ID: "abc",
+					PlayedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), Skipped: true},
+				Listened: 200 * time.Second,
+			}},
+		},
+		{
+			name: "account data",
+			data: `[{"endTime": "2024-03-01 12:05", "msPlayed": 90000, "trackName": "Song", "artistName": "Band"}]`,
+			want: []importedPlay{{
+				firestoreTrack: firestoreTrack{Name: "Song", Artists: "Band",
+					PlayedAt: time.Date(2024, 3, 1, 12, 3, 30, 0, time.UTC)},
+				Listened: 90 * time.Second,
+			}},
+		},
+		{
+			name: "podcast episode left out",
+			data: `[{"ts": "2024-03-01T12:03:20Z", "ms_played": 200000, "episode_name": "Talk"}]`,
+			want: []importedPlay{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSpotifyHistory([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSpotifyHistory() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPlays(t *testing.T) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg = &Config{SkipThreshold: 0.5}
+	t0 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(m int) time.Time { return t0.Add(time.Duration(m) * time.Minute) }
+	play := func(id string, m int, listened time.Duration) importedPlay {
+		return importedPlay{
+			firestoreTrack: firestoreTrack{Name: id, ID: id, PlayedAt: at(m), Duration: 200000},
+			Listened:       listened,
+		}
+	}
+	tests := []struct {
+		name       string
+		stored     []firestoreTrack
+		plays      []importedPlay
+		want       []string // ID@minute, skipped marked with !
+		duplicates int
+		unresolved int
+	}{
+		{
+			name:  "all new, oldest first",
+			plays: []importedPlay{play("b", 10, 0), play("a", 0, 0)},
+			want:  []string{"a@0", "b@10"},
+		},
+		{
+			name:       "repeats within a file are kept",
+			plays:      []importedPlay{play("a", 0, 0), play("a", 3, 0), play("a", 4, 0)},
+			want:       []string{"a@0", "a@3", "a@4"},
+			duplicates: 0,
+		},
+		{
+			name:       "exact repeat within a file is dropped",
+			plays:      []importedPlay{play("a", 0, 0), play("a", 0, 0)},
+			want:       []string{"a@0"},
+			duplicates: 1,
+		},
+		{
+			name:       "stored play nearby is a match",
+			stored:     []firestoreTrack{{ID: "a", PlayedAt: at(2)}},
+			plays:      []importedPlay{play("a", 0, 0), play("a", 10, 0), play("b", 1, 0)},
+			want:       []string{"b@1", "a@10"},
+			duplicates: 1,
+		},
+		{
+			name:       "without ID",
+			plays:      []importedPlay{play("", 0, 0), play("a", 1, 0)},
+			want:       []string{"a@1"},
+			unresolved: 1,
+		},
+		{
+			name:  "listened too little is skipped",
+			plays: []importedPlay{play("a", 0, 30*time.Second), play("b", 5, 3*time.Minute)},
+			want:  []string{"a@0!", "b@5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := newBoltStore(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			if err := store.SaveRecentlyPlayed("user", tt.stored); err != nil {
+				t.Fatal(err)
+			}
+			var progress importProgress
+			tracks, err := newPlays(store, "user", tt.plays, &progress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, tr := range tracks {
+				s := fmt.Sprintf("%s@%d", tr.ID, int(tr.PlayedAt.Sub(t0).Minutes()))
+				if tr.Skipped {
+					s += "!"
+				}
+				got = append(got, s)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newPlays() = %v, want %v", got, tt.want)
+			}
+			if progress.Duplicates != tt.duplicates || progress.Unresolved != tt.unresolved {
+				t.Errorf("newPlays() duplicates %d unresolved %d, want %d and %d",
+					progress.Duplicates, progress.Unresolved, tt.duplicates, tt.unresolved)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	spotify "github.com/chew-z/spotify"
+	"go-spotify/common/plays"
+)
+
+/*processRecentlyPlayed - in-process equivalent of CloudRecent function
+gets tracks user has played since ingestion cursor (kept in user document)
+from Spotify and saves them into database. Database calls give up
+when ctx is done.
+*/
+func processRecentlyPlayed(ctx context.Context, user string) (int, error) {
+	if status := quarantinedStatus(user, true); status != "" {
+		return 0, fmt.Errorf("skipping %s - token is %s", user, status)
+	}
+	store := db.WithContext(ctx)
+	var cursor time.Time
+	var last *plays.Item
+	if u, err := store.GetUser(user); err == nil {
+		cursor = u.IngestCursor
+		if u.IngestLastID != "" {
+			last = &plays.Item{ID: u.IngestLastID, PlayedAt: cursor}
+		}
+	}
+	var newTok firestoreToken
+	newTok.user = user
+	newTok.path = "/user"
+	tok, err := getTokenFromDB(&newTok)
+	if err != nil {
+		return 0, err
+	}
+	spotifyClient := newClient(tok, user, newTok.path)
+	items, gap, err := plays.After(recentlyPlayed(spotifyClient), cursor)
+	if err != nil {
+		return 0, err
+	}
+	if gap {
+		log.Printf("processRecentlyPlayed: Probable gap in history of %s - more than %d plays between %s and %s, older ones are lost",
+			user, plays.Limit, cursor.Format(time.RFC3339), plays.Oldest(items).Format(time.RFC3339))
+	}
+	// the newest play may be left for the next run (see plays.Normalize)
+	normalized := plays.Normalize(last, items, cfg.DedupWindow, cfg.SkipThreshold, time.Now())
+	if len(normalized) == 0 {
+		return 0, nil
+	}
+	tracks := []firestoreTrack{}
+	for _, p := range normalized {
+		tracks = append(tracks, newFirestoreTrack(p))
+	}
+	newest := normalized[len(normalized)-1]
+	if err := store.SaveRecentlyPlayed(user, tracks); err != nil {
+		return 0, err
+	}
+	// move cursor only after tracks are saved
+	if err := store.UpdateUser(user, map[string]interface{}{"ingest_cursor": newest.PlayedAt, "ingest_last_id": newest.ID}); err != nil {
+		log.Printf("processRecentlyPlayed: Error saving cursor for %s %s", user, err.Error())
+	}
+	refreshMoodProfiles(spotifyClient, user)
+	return len(tracks), nil
+}
+
+/*recentlyPlayed - fetches pages of recently played tracks for plays.After
+ */
+func recentlyPlayed(spotifyClient *spotify.Client) func(int64) ([]plays.Item, error) {
+	return func(afterMs int64) ([]plays.Item, error) {
+		got, err := spotifyClient.PlayerRecentlyPlayedOpt(&spotify.RecentlyPlayedOptions{Limit: plays.Limit, AfterEpochMs: afterMs})
+		if err != nil {
+			return nil, err
+		}
+		items := []plays.Item{}
+		for _, item := range got {
+			items = append(items, plays.Item{
+				Name:     item.Track.Name,
+				Artists:  joinArtists(item.Track.Artists, ", "),
+				PlayedAt: item.PlayedAt,
+				ID:       string(item.Track.ID),
+				Duration: item.Track.Duration,
+			})
+		}
+		return items, nil
+	}
+}
+
+/*newFirestoreTrack - play as we keep it in database
+ */
+func newFirestoreTrack(p plays.Play) firestoreTrack {
+	return firestoreTrack{
+		Name:     p.Name,
+		Artists:  p.Artists,
+		PlayedAt: p.PlayedAt,
+		ID:       p.ID,
+		Duration: p.Duration,
+		Skipped:  p.Skipped,
+	}
+}
+
+/*migratePlays - copies legacy history (one record per track, so repeated
+plays have been lost) into plays for every user. Counters aren't touched.
+Run once as go-spotify migrate-plays.
+*/
+func migratePlays(store *encryptedStore) error {
+	users, err := store.Users()
+	if err != nil {
+		return err
+	}
+	total := 0
+	for _, u := range users {
+		n, err := store.MigratePlays(u.ID)
+		if err != nil {
+			return fmt.Errorf("migrate-plays: %s %w", u.ID, err)
+		}
+		log.Printf("migrate-plays: %d plays of %s", n, u.ID)
+		total += n
+	}
+	log.Printf("migrate-plays: %d plays of %d users migrated", total, len(users))
+	return nil
+}
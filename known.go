@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	spotify "github.com/chew-z/spotify"
+)
+
+const (
+	knownTTL         = 7 * 24 * time.Hour // cached set is dropped if not refreshed for so long
+	knownRefresh     = time.Hour          // Spotify library is looked at again after
+	knownLease       = 5 * time.Minute    // one instance refreshes user's known tracks at a time
+	knownPageLimit   = 50                 // saved tracks and playlists asked for at once
+	knownMaxPages    = 20                 // bounds work done in one refresh
+	knownMaxPlaylist = 50                 // own playlists looked into
+)
+
+/*knownTracks - tracks (and artists) user already knows: these in stored
+history, saved tracks and tracks of user's own playlists. Cached per user
+and refreshed incrementally - history from where it has been read before,
+saved tracks newer than the newest one seen, playlists whose snapshot
+has changed. Reading saved tracks or a playlist which takes more than
+knownMaxPages goes on from where it stopped next time.
+*/
+type knownTracks struct {
+	Tracks    map[string]bool   `json:"tracks"`
+	Artists   map[string]bool   `json:"artists"`    // by name (plays keep names only)
+	History   time.Time         `json:"history"`    // played_at of the latest play read
+	SavedAt   string            `json:"saved_at"`   // added_at of the newest saved track, all older ones are read
+	SavedNext int               `json:"saved_next"` // offset to go on reading saved tracks from (0 - done)
+	SavedNew  string            `json:"saved_new"`  // added_at of the newest saved track of the unfinished read
+	Playlists map[string]string `json:"playlists"`  // snapshot IDs of playlists read
+	// playlists read in part - snapshot being read and offset to go on from
+	Partial   map[string]partialPlaylist `json:"partial,omitempty"`
+	Refreshed time.Time                  `json:"refreshed"` // when library was read
+}
+
+type partialPlaylist struct {
+	Snapshot string `json:"snapshot"`
+	Next     int    `json:"next"`
+}
+
+/*knownTracksFor - user's known tracks as cached (nil until they have
+been read for the first time), refreshed in background
+*/
+func knownTracksFor(spotifyClient *spotify.Client, user string) *knownTracks {
+	refreshKnownTracksLater(spotifyClient, user)
+	known := &knownTracks{}
+	if !shared.Get(user, "known", known) || known.Tracks == nil {
+		return nil
+	}
+	return known
+}
+
+/*refreshKnownTracksLater - refreshes known tracks as background work
+of the application unless some instance has done so within knownLease
+*/
+func refreshKnownTracksLater(spotifyClient *spotify.Client, user string) {
+	if app == nil || !shared.Add(user, "known_refreshing", true, knownLease) {
+		return
+	}
+	app.Go(func(ctx context.Context) {
+		refreshKnownTracks(ctx, spotifyClient, user)
+	})
+}
+
+/*refreshKnownTracks - reads history since the last play read and (as
+needed) Spotify library, cached set is replaced only if anything changed
+*/
+func refreshKnownTracks(ctx context.Context, spotifyClient *spotify.Client, user string) {
+	known := &knownTracks{}
+	if !shared.Get(user, "known", known) || known.Tracks == nil {
+		known = &knownTracks{
+			Tracks:    map[string]bool{},
+			Artists:   map[string]bool{},
+			Playlists: map[string]string{},
+		}
+	}
+	changed := known.Refreshed.IsZero()
+	from := time.Time{}
+	if !known.History.IsZero() {
+		from = known.History.Add(time.Millisecond)
+	}
+	err := db.WithContext(ctx).EachPlay(user, from, time.Time{}, func(tr firestoreTrack) error {
+		known.Tracks[tr.ID] = true
+		for _, artist := range splitArtists(tr.Artists) {
+			known.Artists[artist] = true
+		}
+		known.History = tr.PlayedAt
+		changed = true
+		return nil
+	})
+	if err != nil {
+		log.Printf("knownTracksFor: %s %s", user, err.Error())
+	}
+	if ctx.Err() == nil && time.Since(known.Refreshed) > knownRefresh {
+		if err := known.addSaved(spotifyClient); err != nil {
+			log.Printf("knownTracksFor: saved tracks of %s %s", user, err.Error())
+		}
+		if err := known.addPlaylists(spotifyClient, user); err != nil {
+			log.Printf("knownTracksFor: playlists of %s %s", user, err.Error())
+		}
+		known.Refreshed = time.Now()
+		changed = true
+	}
+	if changed {
+		shared.Set(user, "known", known, knownTTL)
+	}
+}
+
+func (k *knownTracks) add(track spotify.FullTrack) {
+	if track.ID == "" {
+		return
+	}
+	k.Tracks[string(track.ID)] = true
+	for _, artist := range track.Artists {
+		k.Artists[artist.Name] = true
+	}
+}
+
+/*addSaved - saved tracks come newest first so we stop at one seen before
+(or at the end). Unfinished read goes on from SavedNext next time and
+SavedAt moves only when everything older has been read.
+*/
+func (k *knownTracks) addSaved(spotifyClient *spotify.Client) error {
+	limit := knownPageLimit
+	for pages := 0; pages < knownMaxPages; pages++ {
+		offset := k.SavedNext
+		page, err := spotifyClient.CurrentUsersTracksOpt(&spotify.Options{Limit: &limit, Offset: &offset})
+		if err != nil {
+			return err
+		}
+		for _, saved := range page.Tracks {
+			if k.SavedAt != "" && saved.AddedAt <= k.SavedAt { // ISO 8601 UTC sorts as time
+				k.savedDone()
+				return nil
+			}
+			if k.SavedNew == "" {
+				k.SavedNew = saved.AddedAt
+			}
+			k.add(saved.FullTrack)
+		}
+		k.SavedNext += len(page.Tracks)
+		if page.Next == "" || len(page.Tracks) == 0 {
+			k.savedDone()
+			return nil
+		}
+	}
+	return nil // the rest next time
+}
+
+func (k *knownTracks) savedDone() {
+	if k.SavedNew != "" {
+		k.SavedAt = k.SavedNew
+	}
+	k.SavedNext, k.SavedNew = 0, ""
+}
+
+/*addPlaylists - tracks of user's own playlists which have changed
+ */
+func (k *knownTracks) addPlaylists(spotifyClient *spotify.Client, user string) error {
+	if k.Partial == nil {
+		k.Partial = map[string]partialPlaylist{}
+	}
+	limit := knownPageLimit
+	page, err := spotifyClient.CurrentUsersPlaylistsOpt(&spotify.Options{Limit: &limit})
+	if err != nil {
+		return err
+	}
+	read := 0
+	for {
+		for _, pl := range page.Playlists {
+			id := string(pl.ID)
+			if pl.Owner.ID != user || k.Playlists[id] == pl.SnapshotID {
+				continue
+			}
+			if read == knownMaxPlaylist { // the rest next time
+				return nil
+			}
+			read++
+			offset := 0
+			if p, ok := k.Partial[id]; ok && p.Snapshot == pl.SnapshotID {
+				offset = p.Next
+			}
+			next, err := k.addPlaylist(spotifyClient, pl.ID, offset)
+			if err != nil {
+				return err
+			}
+			if next > 0 { // snapshot is recorded only when playlist is read whole
+				k.Partial[id] = partialPlaylist{Snapshot: pl.SnapshotID, Next: next}
+				continue
+			}
+			delete(k.Partial, id)
+			k.Playlists[id] = pl.SnapshotID
+		}
+		if err := spotifyClient.NextPage(page); err != nil {
+			if err == spotify.ErrNoMorePages {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+/*addPlaylist - reads at most knownMaxPages of playlist from offset,
+returns offset to go on from (0 - playlist has been read to the end)
+*/
+func (k *knownTracks) addPlaylist(spotifyClient *spotify.Client, id spotify.ID, offset int) (int, error) {
+	limit := 100
+	fields := "items(track(id,artists(name))),next"
+	for pages := 0; pages < knownMaxPages; pages++ {
+		page, err := spotifyClient.GetPlaylistTracksOpt(id, &spotify.Options{Limit: &limit, Offset: &offset}, fields)
+		if err != nil {
+			return offset, err
+		}
+		for _, item := range page.Tracks {
+			k.add(item.Track)
+		}
+		offset += len(page.Tracks)
+		if page.Next == "" || len(page.Tracks) == 0 {
+			return 0, nil
+		}
+	}
+	return offset, nil
+}
+
+/*excludes - true if track is known (or its artist if byArtist)
+ */
+func (k *knownTracks) excludes(id spotify.ID, artists []spotify.SimpleArtist, byArtist bool) bool {
+	if k == nil {
+		return false
+	}
+	if k.Tracks[string(id)] {
+		return true
+	}
+	if byArtist {
+		for _, artist := range artists {
+			if k.Artists[artist.Name] {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -5,9 +5,10 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"cloud.google.com/go/firestore"
 	nice "github.com/ekyoung/gin-nice-recovery"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
@@ -19,53 +20,71 @@ import (
 const sessionTimeout = 24 * 3600 // Session cookie timeout
 
 var (
-	firestoreClient *firestore.Client
-	ctx             = context.Background()
-	sessionSecret   = os.Getenv("SESSION_SECRET")
-	customDomain    = os.Getenv("CUSTOM_DOMAIN")
-	gcrDomain       = os.Getenv("GCR_DOMAIN")
-	redirectURI     = os.Getenv("REDIRECT_URI") // TODO generate callback URI
-	projectID       = os.Getenv("GOOGLE_CLOUD_PROJECT")
-	gae             = os.Getenv("GAE_ENV")
-	gcr             = os.Getenv("GOOGLE_CLOUD_RUN")
-	timezonesURL    = os.Getenv("TIMEZONES_CLOUD_FUNCTION")
+	db     Store
+	shared Cache // shared by instances, see cache.go
+	ctx    = context.Background()
+	cfg    = loadConfig() // see config.go
+	app    *application   // see app.go
 )
 
-func main() {
-	defer firestoreClient.Close()
+// one-off maintenance commands (go-spotify <command>)
+var commands = map[string]func(store *encryptedStore) error{
+	"rotate-keys":   rotateTokenKeys, // see tokencrypt.go
+	"migrate-plays": migratePlays,    // see ingest.go
+	"purge-plays":   purgePlays,      // see retention.go
+	"import":        importCommand,   // see importer.go
 }
 
-func init() {
+func main() {
+	// go-spotify <command> - run maintenance command and exit without starting server
+	if len(os.Args) > 1 {
+		command, ok := commands[os.Args[1]]
+		if !ok {
+			log.Fatalf("Unknown command %s", os.Args[1])
+		}
+		auth.SetAuthInfo(cfg.SpotifyID, cfg.SpotifySecret)
+		store := initStore(ctx)
+		db, shared = store, initCache() // commands may use what handlers use
+		err := command(store)
+		shared.Close()
+		store.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	// Cloud Run sends SIGTERM before stopping instance
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	a, err := newApplication(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 	// Do some lazy initialization to speed up cold start
-	go func() {
-		if gcr == "YES" {
+	a.Go(func(ctx context.Context) {
+		if cfg.GCR == "YES" {
 			log.Printf("Project ID: %s, service account email: %s", getProjectID(), getAccountEmail())
 		}
 		if checkNet() {
 			log.Println("THERE IS NOTHING we can do without access to internet")
 		}
-		stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
-	}()
+	})
+	if err := a.Run(sigCtx); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	firestoreClient = initFirestoreDatabase(ctx)
-	store := cookie.NewStore([]byte(sessionSecret))
+/*newRouter - all routes and middleware of the app
+ */
+func newRouter(conf *Config) *gin.Engine {
+	stripe.Key = conf.StripeSecretKey
+	store := cookie.NewStore([]byte(conf.SessionSecret))
 
 	// router := gin.Default()
 	router := gin.New()      // gin.Default() installs gin.Recovery() so use gin.New() instead
 	router.Use(gin.Logger()) // Install the default logger, not required
 
 	router.Use(sessions.Sessions("go-spotify", store))
-	// A zero/default http.Server, like the one used by the package-level helpers
-	// http.ListenAndServe and http.ListenAndServeTLS, comes with no timeouts.
-	// You don't want that.
-	server := &http.Server{
-		Addr:              ":8080",
-		Handler:           router,
-		ReadHeaderTimeout: 3 * time.Second,
-		ReadTimeout:       10 * time.Second,
-		WriteTimeout:      25 * time.Second,
-		IdleTimeout:       90 * time.Second,
-	}
 	// Install nice.Recovery, passing the handler to call after recovery
 	router.Use(nice.Recovery(recoveryHandler))
 	// Process the templates at the start so that they don't have to be loaded
@@ -102,6 +121,7 @@ func init() {
 	})
 	router.GET("/callback", callback)
 	router.GET("/login", login)
+	router.GET("/authorize", authorize)
 	// Stripe
 	router.POST("/create-checkout-session", handleCreateCheckoutSession)
 	router.POST("/stripe-public-key", handlePublicKey)
@@ -111,6 +131,7 @@ func init() {
 	// Authorization middleware
 	authorized := router.Group("/")
 	authorized.Use(AuthenticationRequired("/user"))
+	authorized.Use(SameOrigin()) // forms can be posted from our pages only
 	{
 		authorized.GET("/payment", func(c *gin.Context) {
 			c.HTML(http.StatusOK, "payment.html", gin.H{
@@ -122,12 +143,21 @@ func init() {
 		authorized.GET("/popular", popular)
 		authorized.GET("/chart", chart)
 		authorized.GET("/history", history)
+		authorized.GET("/history/export", exportHistory)
+		authorized.GET("/history/export/rollups", exportRollups)
+		authorized.GET("/stats", stats)
 		authorized.GET("/mood", moodFromHistory)
+		authorized.GET("/taste", taste)
 		authorized.GET("/playlists", playlists)
 		authorized.GET("/albums", albums)
 		authorized.GET("/user", user)
+		authorized.GET("/import", importPage)
+		authorized.POST("/import", importUpload)
+		authorized.POST("/scrobble", scrobbleSettings)
+		authorized.POST("/retention", retentionSettings)
 		// HIDDEN from menu
 		authorized.GET("/logout", logout)
+		authorized.POST("/logout", logout)
 		authorized.GET("/playlisttracks", playlistTracks)
 		authorized.GET("/albumtracks", albumTracks)
 		// TODO - make useful
@@ -135,6 +165,5 @@ func init() {
 		authorized.GET("/search", search)
 		authorized.GET("/recommend", recommend)
 	}
-	// router.Run()
-	server.ListenAndServe()
+	return router
 }
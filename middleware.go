@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
+	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/patrickmn/go-cache"
 	"golang.org/x/time/rate"
@@ -18,9 +20,9 @@ to custom domain
 */
 func Redirector() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if gcr == "YES" && gcrDomain != customDomain {
-			if domain := c.Request.Host; domain == gcrDomain {
-				url := fmt.Sprintf("https://%s%s", customDomain, c.Request.URL.Path)
+		if cfg.GCR == "YES" && cfg.GcrDomain != cfg.CustomDomain {
+			if domain := c.Request.Host; domain == cfg.GcrDomain {
+				url := fmt.Sprintf("%s%s", cfg.BaseURL, c.Request.URL.Path)
 				if qs := c.Request.URL.RawQuery; qs != "" {
 					url += "?" + qs
 				}
@@ -47,6 +49,34 @@ func Headers() gin.HandlerFunc {
 	}
 }
 
+/*
+SameOrigin - middleware refusing POST requests sent from other sites.
+Browser adds session cookie to them as well so any page could
+otherwise sign user out or change user's settings.
+*/
+func SameOrigin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodPost && !sameOrigin(c.Request) {
+			log.Printf("SameOrigin: refusing %s from %q", c.Request.URL.Path, c.Request.Header.Get("Origin"))
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+/*sameOrigin - true if request comes from a page of the host it is sent to
+(browsers send Origin with every POST, older ones only Referer)
+*/
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Referer()
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && u.Host == r.Host
+}
+
 var limiterSet = cache.New(15*time.Minute, 3*time.Minute)
 
 /*
@@ -74,6 +104,13 @@ func RateLimiter(key func(*gin.Context) string, createLimiter func(*gin.Context)
 
 func recoveryHandler(c *gin.Context, err interface{}) {
 	log.Printf("%v", err)
+	// most likely Spotify refused our token so explain it instead
+	if user, ok := sessions.Default(c).Get("user").(string); ok {
+		if status := quarantinedStatus(user, false); status != "" {
+			reauthorize(c, status)
+			return
+		}
+	}
 	c.HTML(200, "error.html", gin.H{
 		"title": "Error",
 		"err":   err,
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	spotify "github.com/chew-z/spotify"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxRecommendations = 100 // Spotify won't give more at once
+	featuresChunk      = 100 // audio features asked for at once
+)
+
+// history windows - number of latest plays mood is taken from
+var moodWindows = map[string]int{"short": pageLimit, "medium": 50, "long": 200}
+
+// how seed tracks are chosen from history window
+var moodSeedChoices = []string{"latest", "popular", "random"}
+
+/*moodAttribute - audio feature which can be set explicitly on /mood
+ */
+type moodAttribute struct {
+	Name  string
+	Label string
+	Limit float64 // values are from 0 to Limit
+	Step  float64
+}
+
+var moodAttributes = []moodAttribute{
+	{"energy", "Energy", 1, 0.05},
+	{"valence", "Valence", 1, 0.05},
+	{"acousticness", "Acousticness", 1, 0.05},
+	{"danceability", "Danceability", 1, 0.05},
+	{"tempo", "Tempo (BPM)", 250, 1},
+}
+
+/*moodOptions - how /mood recommends, remembered in user document
+ */
+type moodOptions struct {
+	Window   string                     `firestore:"window" json:"window"` // short, medium or long
+	Seeds    int                        `firestore:"seeds" json:"seeds"`   // number of seed tracks (1-5)
+	SeedFrom string                     `firestore:"seed_from" json:"seed_from"`
+	Count    int                        `firestore:"count" json:"count"`         // tracks recommended
+	FromYear int                        `firestore:"from_year" json:"from_year"` // release years (0 - any)
+	ToYear   int                        `firestore:"to_year" json:"to_year"`
+	Targets  map[string]attributeTarget `firestore:"targets,omitempty" json:"targets,omitempty"`
+	// fresh only - leave out tracks user knows (and tracks of artists user knows)
+	Fresh        bool `firestore:"fresh" json:"fresh"`
+	FreshArtists bool `firestore:"fresh_artists" json:"fresh_artists"`
+}
+
+/*attributeTarget - explicit min, max and target of attribute
+overriding these averaged from history
+*/
+type attributeTarget struct {
+	Min    *float64 `firestore:"min,omitempty" json:"min,omitempty"`
+	Max    *float64 `firestore:"max,omitempty" json:"max,omitempty"`
+	Target *float64 `firestore:"target,omitempty" json:"target,omitempty"`
+}
+
+func defaultMoodOptions() moodOptions {
+	// modern tracks not oldies
+	return moodOptions{Window: "short", Seeds: 4, SeedFrom: "latest", Count: pageLimit, FromYear: 1999}
+}
+
+/*userMoodOptions - options submitted with mood form (o=1), which are
+remembered, or these remembered before, or defaults
+*/
+func userMoodOptions(user string, c *gin.Context) (moodOptions, error) {
+	if c.Query("o") == "1" {
+		opts, err := parseMoodOptions(c)
+		if err != nil {
+			return opts, err
+		}
+		return opts, db.UpdateUser(user, map[string]interface{}{"mood_options": opts})
+	}
+	if u, err := db.GetUser(user); err == nil && u.MoodOptions != nil {
+		return *u.MoodOptions, nil
+	}
+	return defaultMoodOptions(), nil
+}
+
+/*parseMoodOptions - options from query, empty fields take defaults
+ */
+func parseMoodOptions(c *gin.Context) (moodOptions, error) {
+	opts := defaultMoodOptions()
+	if window := c.Query("window"); window != "" {
+		if _, ok := moodWindows[window]; !ok {
+			return opts, fmt.Errorf("window must be short, medium or long")
+		}
+		opts.Window = window
+	}
+	if seedFrom := c.Query("seed_from"); seedFrom != "" {
+		if !contains(moodSeedChoices, seedFrom) {
+			return opts, fmt.Errorf("seed_from must be latest, popular or random")
+		}
+		opts.SeedFrom = seedFrom
+	}
+	var err error
+	if opts.Seeds, err = intOption(c, "seeds", opts.Seeds, 1, spotify.MaxNumberOfSeeds); err != nil {
+		return opts, err
+	}
+	if opts.Count, err = intOption(c, "count", opts.Count, 1, maxRecommendations); err != nil {
+		return opts, err
+	}
+	if opts.FromYear, err = yearOption(c, "from_year", opts.FromYear); err != nil {
+		return opts, err
+	}
+	if opts.ToYear, err = yearOption(c, "to_year", opts.ToYear); err != nil {
+		return opts, err
+	}
+	if opts.FromYear > 0 && opts.ToYear > 0 && opts.FromYear > opts.ToYear {
+		return opts, fmt.Errorf("from_year is after to_year")
+	}
+	opts.Fresh = c.Query("fresh") == "1"
+	opts.FreshArtists = opts.Fresh && c.Query("fresh_artists") == "1"
+	for _, a := range moodAttributes {
+		var t attributeTarget
+		if t.Min, err = floatOption(c, a.Name+"_min", a.Limit); err != nil {
+			return opts, err
+		}
+		if t.Max, err = floatOption(c, a.Name+"_max", a.Limit); err != nil {
+			return opts, err
+		}
+		if t.Target, err = floatOption(c, a.Name+"_target", a.Limit); err != nil {
+			return opts, err
+		}
+		if t.Min != nil && t.Max != nil && *t.Min > *t.Max {
+			return opts, fmt.Errorf("%s: min is above max", a.Name)
+		}
+		if t.Min == nil && t.Max == nil && t.Target == nil {
+			continue
+		}
+		if opts.Targets == nil {
+			opts.Targets = map[string]attributeTarget{}
+		}
+		opts.Targets[a.Name] = t
+	}
+	return opts, nil
+}
+
+func intOption(c *gin.Context, name string, value int, min int, max int) (int, error) {
+	s := c.Query(name)
+	if s == "" {
+		return value, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < min || n > max {
+		return value, fmt.Errorf("%s must be a number from %d to %d", name, min, max)
+	}
+	return n, nil
+}
+
+/*yearOption - release year, empty field means any year (0)
+ */
+func yearOption(c *gin.Context, name string, value int) (int, error) {
+	s, ok := c.GetQuery(name)
+	if !ok {
+		return value, nil
+	}
+	if s == "" {
+		return 0, nil
+	}
+	year, err := strconv.Atoi(s)
+	if err != nil || year < 1900 || year > time.Now().Year()+1 {
+		return value, fmt.Errorf("%s must be a year", name)
+	}
+	return year, nil
+}
+
+func floatOption(c *gin.Context, name string, max float64) (*float64, error) {
+	s := c.Query(name)
+	if s == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 || f > max {
+		return nil, fmt.Errorf("%s must be a number from 0 to %g", name, max)
+	}
+	return &f, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+/*seedTracks - up to n unique tracks of plays (most recent first)
+chosen the way user wants
+*/
+func seedTracks(plays []firestoreTrack, n int, from string) []spotify.ID {
+	ids := []spotify.ID{}
+	counts := map[spotify.ID]int{}
+	for _, tr := range plays {
+		id := spotify.ID(tr.ID)
+		if id == "" {
+			continue
+		}
+		if counts[id] == 0 {
+			ids = append(ids, id)
+		}
+		counts[id]++
+	}
+	switch from {
+	case "popular": // most played, the latest first if played as often
+		sort.SliceStable(ids, func(i, j int) bool {
+			return counts[ids[i]] > counts[ids[j]]
+		})
+	case "random":
+		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+		rng.Shuffle(len(ids), func(i, j int) {
+			ids[i], ids[j] = ids[j], ids[i]
+		})
+	}
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+	return ids
+}
+
+/*moodFeatures - audio features of tracks, stored ones first,
+missing ones are asked from Spotify (and kept)
+*/
+func moodFeatures(spotifyClient *spotify.Client, ids []spotify.ID) ([]trackFeatures, error) {
+	keys := []string{}
+	for _, id := range ids {
+		keys = append(keys, string(id))
+	}
+	stored, err := db.TrackFeatures(keys)
+	if err != nil {
+		return nil, err
+	}
+	features := []trackFeatures{}
+	missing := []spotify.ID{}
+	for _, id := range ids {
+		if f, ok := stored[string(id)]; ok {
+			features = append(features, f)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return features, nil
+	}
+	for _, chunk := range chunkIDs(missing, featuresChunk) {
+		fetched, err := spotifyClient.GetAudioFeatures(chunk...)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get audio features of %d track(s): %v", len(chunk), err)
+		}
+		keepFeatures(fetched)
+		for _, f := range fetched {
+			if f != nil {
+				features = append(features, newTrackFeatures(f))
+			}
+		}
+	}
+	return features, nil
+}
+
+/*moodTrackAttributes - attributes averaged from features (as in
+getTrackAttributes) unless user has set them explicitly
+*/
+func moodTrackAttributes(features []trackFeatures, opts moodOptions) *spotify.TrackAttributes {
+	attributes := spotify.NewTrackAttributes()
+	averaged := map[string]func(trackFeatures) float64{
+		"acousticness":     func(f trackFeatures) float64 { return f.Acousticness },
+		"instrumentalness": func(f trackFeatures) float64 { return f.Instrumentalness },
+		"liveness":         func(f trackFeatures) float64 { return f.Liveness },
+		"energy":           func(f trackFeatures) float64 { return f.Energy },
+		"valence":          func(f trackFeatures) float64 { return f.Valence },
+	}
+	setters := attributeSetters(attributes)
+	for name, value := range averaged {
+		if _, ok := opts.Targets[name]; ok || len(features) == 0 {
+			continue
+		}
+		values := []float64{}
+		for _, f := range features {
+			values = append(values, value(f))
+		}
+		average := averageFloat(values)
+		setters[name][0](asAttribute("min", average))
+		setters[name][1](asAttribute("max", average))
+	}
+	applyTargets(attributes, opts.Targets)
+	return attributes
+}
+
+/*attributeSetters - min, max and target setters of attributes by name
+ */
+func attributeSetters(attributes *spotify.TrackAttributes) map[string][3]func(float64) *spotify.TrackAttributes {
+	return map[string][3]func(float64) *spotify.TrackAttributes{
+		"acousticness":     {attributes.MinAcousticness, attributes.MaxAcousticness, attributes.TargetAcousticness},
+		"instrumentalness": {attributes.MinInstrumentalness, attributes.MaxInstrumentalness, attributes.TargetInstrumentalness},
+		"liveness":         {attributes.MinLiveness, attributes.MaxLiveness, attributes.TargetLiveness},
+		"energy":           {attributes.MinEnergy, attributes.MaxEnergy, attributes.TargetEnergy},
+		"valence":          {attributes.MinValence, attributes.MaxValence, attributes.TargetValence},
+		"danceability":     {attributes.MinDanceability, attributes.MaxDanceability, attributes.TargetDanceability},
+		"tempo":            {attributes.MinTempo, attributes.MaxTempo, attributes.TargetTempo},
+	}
+}
+
+/*applyTargets - sets attributes user has set explicitly
+ */
+func applyTargets(attributes *spotify.TrackAttributes, targets map[string]attributeTarget) {
+	setters := attributeSetters(attributes)
+	for name, t := range targets {
+		set, ok := setters[name]
+		if !ok {
+			continue
+		}
+		if t.Min != nil {
+			set[0](*t.Min)
+		}
+		if t.Max != nil {
+			set[1](*t.Max)
+		}
+		if t.Target != nil {
+			set[2](*t.Target)
+		}
+	}
+}
+
+// moodFormRow - attribute row of mood options form
+type moodFormRow struct {
+	moodAttribute
+	Min    string
+	Max    string
+	Target string
+}
+
+/*moodForm - rows of mood options form filled with current options
+ */
+func moodForm(opts moodOptions) []moodFormRow {
+	format := func(f *float64) string {
+		if f == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*f, 'f', -1, 64)
+	}
+	rows := []moodFormRow{}
+	for _, a := range moodAttributes {
+		t := opts.Targets[a.Name]
+		rows = append(rows, moodFormRow{a, format(t.Min), format(t.Max), format(t.Target)})
+	}
+	return rows
+}
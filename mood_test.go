@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*queryContext - gin context of GET request with query
+ */
+func queryContext(query string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/mood?"+query, nil)
+	return c
+}
+
+func TestYearOption(t *testing.T) {
+	next := strconv.Itoa(time.Now().Year() + 1)
+	tests := []struct {
+		name    string
+		query   string
+		value   int
+		want    int
+		wantErr bool
+	}{
+		{"missing keeps value", "", 1999, 1999, false},
+		{"empty means any year", "year=", 1999, 0, false},
+		{"year", "year=1985", 1999, 1985, false},
+		{"first year", "year=1900", 0, 1900, false},
+		{"next year", "year=" + next, 0, time.Now().Year() + 1, false},
+		{"too early", "year=1899", 1999, 1999, true},
+		{"too late", "year=3000", 1999, 1999, true},
+		{"not a number", "year=eighties", 1999, 1999, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := yearOption(queryContext(tt.query), "year", tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("yearOption() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("yearOption() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMoodOptions(t *testing.T) {
+	defaults := defaultMoodOptions()
+	tests := []struct {
+		name    string
+		query   string
+		check   func(moodOptions) bool
+		wantErr bool
+	}{
+		{
+			name:  "defaults",
+			query: "o=1",
+			check: func(o moodOptions) bool {
+				return o.Window == defaults.Window && o.Seeds == defaults.Seeds && o.SeedFrom == defaults.SeedFrom &&
+					o.Count == defaults.Count && o.FromYear == defaults.FromYear && o.ToYear == 0 && o.Targets == nil && !o.Fresh
+			},
+		},
+		{
+			name:  "all set",
+			query: "window=long&seed_from=popular&seeds=2&count=50&from_year=1970&to_year=1989&fresh=1&fresh_artists=1",
+			check: func(o moodOptions) bool {
+				return o.Window == "long" && o.SeedFrom == "popular" && o.Seeds == 2 && o.Count == 50 &&
+					o.FromYear == 1970 && o.ToYear == 1989 && o.Fresh && o.FreshArtists
+			},
+		},
+		{
+			name:  "any year",
+			query: "from_year=&to_year=",
+			check: func(o moodOptions) bool { return o.FromYear == 0 && o.ToYear == 0 },
+		},
+		{
+			name:  "fresh artists only with fresh",
+			query: "fresh_artists=1",
+			check: func(o moodOptions) bool { return !o.Fresh && !o.FreshArtists },
+		},
+		{
+			name:  "attribute targets",
+			query: "energy_min=0.2&energy_max=0.8&tempo_target=120",
+			check: func(o moodOptions) bool {
+				e, tempo := o.Targets["energy"], o.Targets["tempo"]
+				return len(o.Targets) == 2 && *e.Min == 0.2 && *e.Max == 0.8 && e.Target == nil && *tempo.Target == 120
+			},
+		},
+		{name: "unknown window", query: "window=huge", wantErr: true},
+		{name: "unknown seed choice", query: "seed_from=oldest", wantErr: true},
+		{name: "too many seeds", query: "seeds=6", wantErr: true},
+		{name: "no seeds", query: "seeds=0", wantErr: true},
+		{name: "too many tracks", query: "count=101", wantErr: true},
+		{name: "years swapped", query: "from_year=2000&to_year=1990", wantErr: true},
+		{name: "bad year", query: "to_year=99", wantErr: true},
+		{name: "attribute out of range", query: "valence_max=1.5", wantErr: true},
+		{name: "min above max", query: "energy_min=0.9&energy_max=0.1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMoodOptions(queryContext(tt.query))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMoodOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !tt.check(got) {
+				t.Errorf("parseMoodOptions() = %+v", got)
+			}
+		})
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
-	"os"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stripe/stripe-go"
@@ -40,14 +39,14 @@ func handleCreateCheckoutSession(c *gin.Context) {
 			SubscriptionData: &stripe.CheckoutSessionSubscriptionDataParams{
 				Items: []*stripe.CheckoutSessionSubscriptionDataItemsParams{
 					&stripe.CheckoutSessionSubscriptionDataItemsParams{
-						Plan: stripe.String(os.Getenv("SUBSCRIPTION_PLAN_ID")),
+						Plan: stripe.String(cfg.SubscriptionPlanID),
 					},
 				},
 			},
 			// TODO - paymentsuccess is for testing, redirect to /user in production
 			// SuccessURL:        stripe.String("https://" + os.Getenv("CUSTOM_DOMAIN") + "/paymentsuccess?session_id={CHECKOUT_SESSION_ID}"),
-			SuccessURL:        stripe.String("https://" + os.Getenv("CUSTOM_DOMAIN") + "/user"),
-			CancelURL:         stripe.String("https://" + os.Getenv("CUSTOM_DOMAIN") + "/user"),
+			SuccessURL:        stripe.String(cfg.BaseURL + "/user"),
+			CancelURL:         stripe.String(cfg.BaseURL + "/user"),
 			ClientReferenceID: stripe.String(userID),
 			CustomerEmail:     stripe.String(userEmail), //TODO - this is unverified email. Is is necessary? CustomerEmail or Customer not both
 		}
@@ -76,7 +75,7 @@ func handleCreateCheckoutSession(c *gin.Context) {
 }
 
 func handlePublicKey(c *gin.Context) {
-	publicKey := os.Getenv("STRIPE_PUBLISHABLE_KEY")
+	publicKey := cfg.StripePublishableKey
 	c.JSON(http.StatusOK, gin.H{"publicKey": publicKey})
 }
 
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	spotify "github.com/chew-z/spotify"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	profileDays      = 90                 // history profiles are learned from
+	profileTTL       = 24 * time.Hour     // profiles are learned again after
+	profileKeep      = 7 * 24 * time.Hour // cached profiles are served while new ones are learned
+	profileLease     = 5 * time.Minute    // one instance learns user's profiles at a time
+	profileMinPlays  = 20                 // plays a slot needs to be used
+	profileTopTracks = 20                 // most played tracks kept for seeds
+	profileBlock     = 4                  // hours in a time slot
+)
+
+// names of 4 hour blocks of a day
+var profileBlocks = []string{"night", "early morning", "morning", "afternoon", "evening", "late evening"}
+
+/*moodProfiles - user's audio features by time of day (in user's timezone).
+Every play counts into four slots - its weekday and block, workday or
+weekend and block, any day and block, and any time - so sparse slots
+can fall back to wider ones.
+*/
+type moodProfiles struct {
+	Timezone string                  `json:"timezone"`
+	Built    time.Time               `json:"built"`
+	Slots    map[string]*moodProfile `json:"slots"`
+}
+
+/*moodProfile - sums of features of plays in a slot (mean and standard
+deviation are computed from them), its most played tracks and its
+latest played tracks
+*/
+type moodProfile struct {
+	Plays  int                `json:"plays"`
+	Sum    map[string]float64 `json:"sum"`
+	SumSq  map[string]float64 `json:"sum_sq"`
+	Tracks map[string]int     `json:"tracks"`
+	Latest []string           `json:"latest"` // newest first
+}
+
+// slots a play at t counts into, narrowest first
+func profileSlots(t time.Time) []string {
+	block := profileBlocks[t.Hour()/profileBlock]
+	days := "workday"
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		days = "weekend"
+	}
+	return []string{
+		fmt.Sprintf("%s %s", t.Weekday(), block),
+		fmt.Sprintf("%s %s", days, block),
+		fmt.Sprintf("any day %s", block),
+		"any time",
+	}
+}
+
+func (p *moodProfile) add(f trackFeatures) {
+	if p.Sum == nil {
+		p.Sum, p.SumSq, p.Tracks = map[string]float64{}, map[string]float64{}, map[string]int{}
+	}
+	p.Plays++
+	for name, v := range featureValues(f) {
+		p.Sum[name] += v
+		p.SumSq[name] += v * v
+	}
+	p.Tracks[f.ID]++
+	// plays come oldest first so the track moves to the front
+	latest := []string{f.ID}
+	for _, id := range p.Latest {
+		if id != f.ID && len(latest) < profileTopTracks {
+			latest = append(latest, id)
+		}
+	}
+	p.Latest = latest
+}
+
+func featureValues(f trackFeatures) map[string]float64 {
+	return map[string]float64{
+		"acousticness":     f.Acousticness,
+		"danceability":     f.Danceability,
+		"energy":           f.Energy,
+		"instrumentalness": f.Instrumentalness,
+		"valence":          f.Valence,
+		"tempo":            f.Tempo,
+	}
+}
+
+func (p *moodProfile) mean(name string) float64 {
+	return p.Sum[name] / float64(p.Plays)
+}
+
+func (p *moodProfile) std(name string) float64 {
+	mean := p.mean(name)
+	return math.Sqrt(math.Max(p.SumSq[name]/float64(p.Plays)-mean*mean, 0))
+}
+
+/*trim - keeps only most played tracks (enough for seeds)
+ */
+func (p *moodProfile) trim() {
+	ids := p.topTracks()
+	if len(ids) <= profileTopTracks {
+		return
+	}
+	tracks := map[string]int{}
+	for _, id := range ids[:profileTopTracks] {
+		tracks[id] = p.Tracks[id]
+	}
+	p.Tracks = tracks
+}
+
+// track IDs most played first
+func (p *moodProfile) topTracks() []string {
+	ids := []string{}
+	for id := range p.Tracks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if p.Tracks[ids[i]] == p.Tracks[ids[j]] {
+			return ids[i] < ids[j]
+		}
+		return p.Tracks[ids[i]] > p.Tracks[ids[j]]
+	})
+	return ids
+}
+
+/*userMoodProfiles - cached profiles (nil if there are none yet). Missing
+profiles, profiles older than profileTTL and these learned in another
+timezone are learned again in the background - the request never waits
+for 90 days of history.
+*/
+func userMoodProfiles(spotifyClient *spotify.Client, user string, location *time.Location) *moodProfiles {
+	var profiles moodProfiles
+	found := shared.Get(user, "profiles", &profiles)
+	if !found || time.Since(profiles.Built) > profileTTL || profiles.Timezone != location.String() {
+		learnMoodProfilesLater(spotifyClient, user, location)
+	}
+	if !found {
+		return nil
+	}
+	return &profiles
+}
+
+/*refreshMoodProfiles - after ingestion learns cached profiles again
+once they are older than profileTTL (users who never asked for them
+are left alone)
+*/
+func refreshMoodProfiles(spotifyClient *spotify.Client, user string) {
+	var profiles moodProfiles
+	if !shared.Get(user, "profiles", &profiles) || time.Since(profiles.Built) <= profileTTL {
+		return
+	}
+	location, err := time.LoadLocation(profiles.Timezone)
+	if err != nil {
+		location = cfg.Location
+	}
+	learnMoodProfilesLater(spotifyClient, user, location)
+}
+
+/*learnMoodProfilesLater - learns profiles as background work of the
+application unless some instance is learning them already
+*/
+func learnMoodProfilesLater(spotifyClient *spotify.Client, user string, location *time.Location) {
+	if app == nil || !shared.Add(user, "profiles_learning", true, profileLease) {
+		return
+	}
+	app.Go(func(ctx context.Context) {
+		if err := learnMoodProfiles(ctx, spotifyClient, user, location); err != nil {
+			log.Printf("learnMoodProfiles: %s %s", user, err.Error())
+		}
+	})
+}
+
+/*learnMoodProfiles - profiles learned from the last profileDays of
+history in location, kept in cache
+*/
+func learnMoodProfiles(ctx context.Context, spotifyClient *spotify.Client, user string, location *time.Location) error {
+	plays := []firestoreTrack{}
+	err := db.WithContext(ctx).EachPlay(user, time.Now().AddDate(0, 0, -profileDays), time.Time{}, func(tr firestoreTrack) error {
+		if !tr.Skipped && tr.ID != "" {
+			plays = append(plays, tr)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	ids := []spotify.ID{}
+	seen := map[string]bool{}
+	for _, tr := range plays {
+		if !seen[tr.ID] {
+			seen[tr.ID] = true
+			ids = append(ids, spotify.ID(tr.ID))
+		}
+	}
+	features, err := moodFeatures(spotifyClient, ids)
+	if err != nil {
+		return err
+	}
+	byID := map[string]trackFeatures{}
+	for _, f := range features {
+		byID[f.ID] = f
+	}
+	profiles := buildMoodProfiles(plays, byID, location)
+	log.Printf("learnMoodProfiles: %d plays of %s in %d slots", len(plays), user, len(profiles.Slots))
+	shared.Set(user, "profiles", profiles, profileKeep)
+	return nil
+}
+
+/*buildMoodProfiles - profiles of plays (oldest first) with features
+ */
+func buildMoodProfiles(plays []firestoreTrack, features map[string]trackFeatures, location *time.Location) moodProfiles {
+	profiles := moodProfiles{Timezone: location.String(), Built: time.Now(), Slots: map[string]*moodProfile{}}
+	for _, tr := range plays {
+		f, ok := features[tr.ID]
+		if !ok {
+			continue
+		}
+		for _, slot := range profileSlots(tr.PlayedAt.In(location)) {
+			p, ok := profiles.Slots[slot]
+			if !ok {
+				p = &moodProfile{}
+				profiles.Slots[slot] = p
+			}
+			p.add(f)
+		}
+	}
+	for _, p := range profiles.Slots {
+		p.trim()
+	}
+	return profiles
+}
+
+/*profileFor - profile of the narrowest slot of t with enough plays
+ */
+func (mp *moodProfiles) profileFor(t time.Time) (string, *moodProfile) {
+	for _, slot := range profileSlots(t) {
+		if p, ok := mp.Slots[slot]; ok && p.Plays >= profileMinPlays {
+			return slot, p
+		}
+	}
+	return "", nil
+}
+
+/*seeds - slot's tracks the way user wants seeds chosen: latest played,
+most played or most played in random order
+*/
+func (p *moodProfile) seeds(from string, rng *rand.Rand) []string {
+	switch from {
+	case "latest":
+		if len(p.Latest) > 0 { // profiles cached before latest tracks were kept
+			return append([]string{}, p.Latest...)
+		}
+	case "random":
+		seeds := p.topTracks()
+		rng.Shuffle(len(seeds), func(i, j int) {
+			seeds[i], seeds[j] = seeds[j], seeds[i]
+		})
+		return seeds
+	}
+	return p.topTracks()
+}
+
+/*profileTrackAttributes - ranges of slot (mean plus minus standard
+deviation) unless user has set attributes explicitly
+*/
+func profileTrackAttributes(p *moodProfile, opts moodOptions) *spotify.TrackAttributes {
+	attributes := spotify.NewTrackAttributes()
+	setters := attributeSetters(attributes)
+	for name := range p.Sum {
+		if _, ok := opts.Targets[name]; ok {
+			continue
+		}
+		mean, spread := p.mean(name), p.std(name)
+		if name == "tempo" {
+			spread = math.Max(spread, 5) // BPM
+		} else {
+			spread = math.Max(spread, 0.05) // slot of very alike tracks still gets some room
+		}
+		min, max := math.Max(mean-spread, 0), mean+spread
+		if name != "tempo" {
+			max = math.Min(max, 1)
+		}
+		setters[name][0](min)
+		setters[name][1](max)
+		setters[name][2](mean)
+	}
+	applyTargets(attributes, opts.Targets)
+	return attributes
+}
+
+/*recommendFromProfile - like recommendFromHistory but seeds and attributes
+come from what user listens to at this time of day and week (/mood?when=now).
+Returns name of time slot used.
+*/
+func recommendFromProfile(spotifyClient *spotify.Client, c *gin.Context, opts moodOptions) ([]spotify.FullTrack, string, error) {
+	session := sessions.Default(c)
+	user := session.Get("user").(string)
+	country := session.Get("country").(string)
+	location := userTimezone(user, c.Query("tz"))
+	profiles := userMoodProfiles(spotifyClient, user, location)
+	if profiles == nil {
+		return nil, "", errors.New("Your listening by time of day is being learned, try again in a minute")
+	}
+	slot, profile := profiles.profileFor(time.Now().In(location))
+	if profile == nil {
+		return nil, "", errors.New("Not enough history to know your mood at this time yet")
+	}
+	seeds := profile.seeds(opts.SeedFrom, rand.New(rand.NewSource(time.Now().UnixNano())))
+	if len(seeds) > opts.Seeds {
+		seeds = seeds[:opts.Seeds]
+	}
+	params := recommendationParameters{
+		FromYear:        opts.FromYear,
+		ToYear:          opts.ToYear,
+		MinTrackCount:   opts.Count,
+		TrackAttributes: profileTrackAttributes(profile, opts),
+	}
+	for _, id := range seeds {
+		params.Seeds.Tracks = append(params.Seeds.Tracks, spotify.ID(id))
+	}
+	if opts.Fresh {
+		params.Known = knownTracksFor(spotifyClient, user)
+		params.KnownArtists = opts.FreshArtists
+	}
+	tracks, err := getRecommendedTracks(spotifyClient, params, &country)
+	return tracks, slot, err
+}
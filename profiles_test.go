@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMoodProfileSeeds(t *testing.T) {
+	monday := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC) // Monday morning
+	plays := []firestoreTrack{}
+	for i, id := range []string{"a", "b", "a", "c", "a", "b", "d"} {
+		plays = append(plays, firestoreTrack{ID: id, PlayedAt: monday.Add(time.Duration(i) * time.Minute)})
+	}
+	features := map[string]trackFeatures{}
+	for _, id := range []string{"a", "b", "c", "d"} {
+		features[id] = trackFeatures{ID: id, Energy: 0.5, Tempo: 120}
+	}
+	profiles := buildMoodProfiles(plays, features, time.UTC)
+	p := profiles.Slots["Monday morning"]
+	if p == nil || p.Plays != len(plays) {
+		t.Fatalf("buildMoodProfiles() slots %v", profiles.Slots)
+	}
+	tests := []struct {
+		name string
+		from string
+		want []string
+	}{
+		{"latest played first", "latest", []string{"d", "b", "a", "c"}},
+		{"most played first", "popular", []string{"a", "b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.seeds(tt.from, rand.New(rand.NewSource(1))); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("seeds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	t.Run("random has the same tracks", func(t *testing.T) {
+		got := map[string]bool{}
+		for _, id := range p.seeds("random", rand.New(rand.NewSource(1))) {
+			got[id] = true
+		}
+		if len(got) != 4 {
+			t.Errorf("seeds() = %v", got)
+		}
+	})
+	t.Run("latest of profiles cached without it", func(t *testing.T) {
+		old := &moodProfile{Tracks: p.Tracks}
+		if got := old.seeds("latest", nil); !reflect.DeepEqual(got, old.topTracks()) {
+			t.Errorf("seeds() = %v", got)
+		}
+	})
+}
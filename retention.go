@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// how often retention is applied in-process (with local database)
+const retentionInterval = 24 * time.Hour
+
+/*retentionDays - how long user's plays are kept. It depends on plan
+(premium keeps longer), user's own retention_days wins if it is shorter -
+a setting saved under premium doesn't outlive the subscription.
+*/
+func retentionDays(u firestoreUser, conf *Config) int {
+	days := planRetentionDays(u, conf)
+	if u.RetentionDays > 0 && u.RetentionDays < days {
+		return u.RetentionDays
+	}
+	return days
+}
+
+/*planRetentionDays - retention of user's plan (user's own setting left out)
+ */
+func planRetentionDays(u firestoreUser, conf *Config) int {
+	if u.Premium && (u.Expiration.IsZero() || u.Expiration.After(time.Now())) {
+		return conf.PremiumRetentionDays
+	}
+	return conf.RetentionDays
+}
+
+/*retentionSettings - user's own retention, POST /retention with days
+(empty or 0 - plan default). Plays can be kept shorter than plan allows,
+not longer.
+*/
+func retentionSettings(c *gin.Context) {
+	user, _ := sessions.Default(c).Get("user").(string)
+	u, err := db.GetUser(user)
+	if err != nil {
+		log.Printf("/retention: %s %s", user, err.Error())
+		c.String(http.StatusInternalServerError, "Failed to read user")
+		return
+	}
+	plan := planRetentionDays(*u, cfg)
+	days := 0
+	if v := strings.TrimSpace(c.PostForm("days")); v != "" {
+		if days, err = strconv.Atoi(v); err != nil || days < 0 || days > plan {
+			c.String(http.StatusBadRequest, "Days must be a number from 1 to %d (or 0 for plan default)", plan)
+			return
+		}
+	}
+	if err := db.UpdateUser(user, map[string]interface{}{"retention_days": days}); err != nil {
+		log.Printf("/retention: %s %s", user, err.Error())
+		c.String(http.StatusInternalServerError, "Failed to save retention")
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/user")
+}
+
+/*exportRollups - streams monthly rollups of user's purged plays
+as JSON Lines, oldest month first
+/history/export/rollups
+*/
+func exportRollups(c *gin.Context) {
+	user, _ := sessions.Default(c).Get("user").(string)
+	rollups, err := db.Rollups(user)
+	if err != nil {
+		log.Printf("/history/export/rollups: %s %s", user, err.Error())
+		c.String(http.StatusInternalServerError, "Failed to read rollups")
+		return
+	}
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="rollups-%s.jsonl"`, user))
+	enc := json.NewEncoder(c.Writer)
+	for _, r := range rollups {
+		if err := enc.Encode(r); err != nil {
+			c.Error(err)
+			return
+		}
+	}
+}
+
+/*applyRetention - rolls up and deletes plays of every user which
+are older than user's retention. Same as MidnightRun function does
+for Firestore.
+*/
+func applyRetention(store Store) error {
+	users, err := store.Users()
+	if err != nil {
+		return err
+	}
+	total := 0
+	for _, u := range users {
+		days := retentionDays(u, cfg)
+		n, err := store.PurgePlays(u.ID, time.Now().AddDate(0, 0, -days))
+		if err != nil {
+			log.Printf("applyRetention: %s %s", u.ID, err.Error())
+			continue
+		}
+		if n > 0 {
+			log.Printf("applyRetention: %d plays of %s older than %d days rolled up", n, u.ID, days)
+		}
+		total += n
+	}
+	log.Printf("applyRetention: %d plays of %d users rolled up", total, len(users))
+	return nil
+}
+
+/*purgePlays - purge-plays command
+ */
+func purgePlays(store *encryptedStore) error {
+	return applyRetention(store)
+}
+
+/*retentionLoop - applies retention daily until ctx is done (see application.Go)
+ */
+func retentionLoop(ctx context.Context) {
+	timer := time.NewTimer(time.Minute) // not right on start
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		if err := applyRetention(db.WithContext(ctx)); err != nil {
+			log.Printf("retentionLoop: %s", err.Error())
+		}
+		timer.Reset(retentionInterval)
+	}
+}
+
+/*rollupMonth - month (UTC) play falls into, also rollup document ID
+ */
+func rollupMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+/*addPlay - counts play into rollup. Skipped plays are counted
+as skips only, minutes are taken from track duration.
+*/
+func (r *monthlyRollup) addPlay(tr firestoreTrack) {
+	if tr.Skipped {
+		r.Skips++
+		return
+	}
+	r.Plays++
+	r.Minutes += float64(tr.Duration) / float64(time.Minute/time.Millisecond)
+	if r.Tracks == nil {
+		r.Tracks = map[string]int{}
+	}
+	if r.Artists == nil {
+		r.Artists = map[string]int{}
+	}
+	if tr.ID != "" {
+		r.Tracks[tr.ID]++
+	}
+	for _, artist := range splitArtists(tr.Artists) {
+		r.Artists[artist]++
+	}
+}
+
+/*merge - adds other rollup of the same month
+ */
+func (r *monthlyRollup) merge(other monthlyRollup) {
+	r.Plays += other.Plays
+	r.Skips += other.Skips
+	r.Minutes += other.Minutes
+	if r.Tracks == nil {
+		r.Tracks = map[string]int{}
+	}
+	if r.Artists == nil {
+		r.Artists = map[string]int{}
+	}
+	for id, n := range other.Tracks {
+		r.Tracks[id] += n
+	}
+	for artist, n := range other.Artists {
+		r.Artists[artist] += n
+	}
+}
+
+/*rollupPlays - plays summed up by month
+ */
+func rollupPlays(tracks []firestoreTrack) map[string]*monthlyRollup {
+	rollups := map[string]*monthlyRollup{}
+	for _, tr := range tracks {
+		month := rollupMonth(tr.PlayedAt)
+		r, ok := rollups[month]
+		if !ok {
+			r = &monthlyRollup{Month: month}
+			rollups[month] = r
+		}
+		r.addPlay(tr)
+	}
+	return rollups
+}
+
+/*splitArtists - reverses joinArtists(artists, ", ")
+ */
+func splitArtists(artists string) []string {
+	names := []string{}
+	for _, name := range strings.Split(artists, ", ") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// failing user is retried no later than that
+const maxIngestBackoff = 24 * time.Hour
+
+/*ingestScheduler - polls recently played tracks of every active user
+(token not quarantined) every interval (with jitter) so history grows
+without CloudRecent function. At most concurrency users are processed
+at once, users failing in a row are retried less and less often.
+*/
+type ingestScheduler struct {
+	interval    time.Duration
+	concurrency int
+	ingest      func(ctx context.Context, user string) (int, error)
+
+	mu      sync.Mutex
+	backoff map[string]ingestBackoff
+	rngMu   sync.Mutex
+	rng     *rand.Rand
+}
+
+type ingestBackoff struct {
+	failures int
+	next     time.Time
+}
+
+func newIngestScheduler(interval time.Duration, concurrency int) *ingestScheduler {
+	return &ingestScheduler{
+		interval:    interval,
+		concurrency: concurrency,
+		ingest:      processRecentlyPlayed,
+		backoff:     map[string]ingestBackoff{},
+		rng:         rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+/*Run - polls until ctx is done (see application.Go)
+ */
+func (s *ingestScheduler) Run(ctx context.Context) {
+	log.Printf("ingestScheduler: every %s, %d users at once", s.interval, s.concurrency)
+	timer := time.NewTimer(s.jitter(s.interval / 10)) // don't hit Spotify right on start
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		s.round(ctx)
+		timer.Reset(s.jitter(s.interval))
+	}
+}
+
+/*round - processes every active user once
+ */
+func (s *ingestScheduler) round(ctx context.Context) {
+	users, err := db.WithContext(ctx).Users()
+	if err != nil {
+		log.Printf("ingestScheduler: Error retrieving users %s", err.Error())
+		return
+	}
+	sem := make(chan struct{}, s.concurrency)
+	var wg sync.WaitGroup
+	var processed, tracks int
+	var mu sync.Mutex
+	for _, u := range users {
+		if tokenQuarantined(u.TokenStatus) || !s.due(u.ID) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(user string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			n, err := s.ingest(ctx, user)
+			s.done(user, err)
+			if err != nil {
+				log.Printf("ingestScheduler: %s %s", user, err.Error())
+				return
+			}
+			mu.Lock()
+			processed++
+			tracks += n
+			mu.Unlock()
+		}(u.ID)
+	}
+	wg.Wait()
+	log.Printf("ingestScheduler: %d tracks of %d users", tracks, processed)
+}
+
+/*due - false if user is backing off
+ */
+func (s *ingestScheduler) due(user string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.backoff[user]
+	return !ok || time.Now().After(b.next)
+}
+
+/*done - remembers failure (doubling the wait) or forgets it
+ */
+func (s *ingestScheduler) done(user string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.backoff, user)
+		return
+	}
+	b := s.backoff[user]
+	b.failures++
+	wait := s.interval << uint(b.failures)
+	if wait > maxIngestBackoff || wait <= 0 {
+		wait = maxIngestBackoff
+	}
+	b.next = time.Now().Add(s.jitter(wait))
+	s.backoff[user] = b
+}
+
+/*jitter - d ± 10% so instances (and users) don't poll in lockstep
+ */
+func (s *ingestScheduler) jitter(d time.Duration) time.Duration {
+	spread := int64(d / 5)
+	if spread <= 0 {
+		return d
+	}
+	s.rngMu.Lock()
+	defer s.rngMu.Unlock()
+	return d - time.Duration(spread/2) + time.Duration(s.rng.Int63n(spread))
+}
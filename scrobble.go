@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultScrobbleURL = "https://api.listenbrainz.org"
+	// ListenBrainz token is kept (encrypted) in user document under this name
+	scrobbleIntegration = "listenbrainz"
+	scrobbleBatch       = 100 // listens submitted at once
+	scrobbleMax         = 1000
+	scrobbleAttempts    = 3
+)
+
+var (
+	errScrobbleToken = errors.New("scrobble: token rejected by server")
+	errScrobbleURL   = errors.New("scrobble: server URL must be https URL of a public host")
+	// tests replace it to reach a stand-in server on loopback
+	scrobbleDialGuard = publicOnly
+	// server URL comes from user so we connect only to public addresses
+	// (checked when connecting, after DNS) and follow only https redirects
+	scrobbleClient = &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: 5 * time.Second,
+				Control: func(network, address string, c syscall.RawConn) error {
+					return scrobbleDialGuard(network, address, c)
+				},
+			}).DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme != "https" || len(via) >= 3 {
+				return errScrobbleURL
+			}
+			return nil
+		},
+	}
+)
+
+// listen as ListenBrainz API wants it
+type listen struct {
+	ListenedAt int64 `json:"listened_at"`
+	Metadata   struct {
+		Artist string                 `json:"artist_name"`
+		Track  string                 `json:"track_name"`
+		Info   map[string]interface{} `json:"additional_info,omitempty"`
+	} `json:"track_metadata"`
+}
+
+/*scrobbleLoop - every SCROBBLE_INTERVAL submits plays stored since
+scrobble cursor of every user who scrobbles, whichever way plays got
+stored (CloudRecent or in-process ingestion). A user is claimed in shared
+cache so only one instance scrobbles them at a time. Runs until ctx is
+done (see application.Go).
+*/
+func scrobbleLoop(ctx context.Context) {
+	interval := cfg.ScrobbleInterval
+	timer := time.NewTimer(time.Minute) // not right on start
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		users, err := db.WithContext(ctx).Users()
+		if err != nil {
+			log.Printf("scrobbleLoop: Error retrieving users %s", err.Error())
+		}
+		for _, u := range users {
+			if ctx.Err() != nil {
+				return
+			}
+			if u.ScrobbleURL == "" || !shared.Add(u.ID, "scrobbling", true, interval) {
+				continue
+			}
+			if n, err := scrobblePlays(ctx, u.ID); err != nil {
+				log.Printf("scrobbleLoop: %s %s", u.ID, err.Error())
+			} else if n > 0 {
+				log.Printf("scrobbleLoop: %d plays of %s scrobbled", n, u.ID)
+			}
+		}
+		timer.Reset(interval)
+	}
+}
+
+/*scrobblePlays - submits user's plays saved since scrobble cursor
+to ListenBrainz-compatible server in batches. Cursor is moved after
+every accepted batch so nothing is sent twice (server de-duplicates
+listens with the same time and track anyway). Skipped plays aren't
+listens so they are left out. Returns number of listens submitted.
+*/
+func scrobblePlays(ctx context.Context, user string) (int, error) {
+	store := db.WithContext(ctx)
+	u, err := store.GetUser(user)
+	if err != nil || u.ScrobbleURL == "" {
+		return 0, err
+	}
+	token, err := openIntegration(tokenCipher, user, scrobbleIntegration, u.Integrations[scrobbleIntegration])
+	if err != nil {
+		return 0, err
+	}
+	if token == "" {
+		return 0, store.UpdateUser(user, map[string]interface{}{"scrobble_url": ""})
+	}
+	plays := []firestoreTrack{}
+	from := u.ScrobbleCursor.Add(time.Millisecond)
+	errEnough := errors.New("enough")
+	err = store.EachPlay(user, from, time.Time{}, func(tr firestoreTrack) error {
+		plays = append(plays, tr)
+		if len(plays) == scrobbleMax { // the rest next time
+			return errEnough
+		}
+		return nil
+	})
+	if err != nil && err != errEnough {
+		return 0, err
+	}
+	submitted := 0
+	for start := 0; start < len(plays); start += scrobbleBatch {
+		end := start + scrobbleBatch
+		if end > len(plays) {
+			end = len(plays)
+		}
+		listens := []listen{}
+		for _, tr := range plays[start:end] {
+			if !tr.Skipped {
+				listens = append(listens, newListen(tr))
+			}
+		}
+		status := map[string]interface{}{"scrobble_updated": time.Now()}
+		err := submitListens(ctx, u.ScrobbleURL, token, listens)
+		if err != nil {
+			status["scrobble_error"] = err.Error()
+			if uerr := store.UpdateUser(user, status); uerr != nil {
+				log.Printf("scrobblePlays: %s %s", user, uerr.Error())
+			}
+			return submitted, err
+		}
+		submitted += len(listens)
+		status["scrobble_cursor"] = plays[end-1].PlayedAt
+		status["scrobble_error"] = ""
+		status["scrobble_count"] = u.ScrobbleCount + submitted
+		if err := store.UpdateUser(user, status); err != nil {
+			return submitted, err
+		}
+	}
+	return submitted, nil
+}
+
+func newListen(tr firestoreTrack) listen {
+	var l listen
+	l.ListenedAt = tr.PlayedAt.Unix()
+	l.Metadata.Artist = tr.Artists
+	l.Metadata.Track = tr.Name
+	l.Metadata.Info = map[string]interface{}{
+		"music_service":     "spotify.com",
+		"submission_client": "go-spotify",
+	}
+	if tr.ID != "" {
+		l.Metadata.Info["spotify_id"] = "https://open.spotify.com/track/" + tr.ID
+	}
+	if tr.Duration > 0 {
+		l.Metadata.Info["duration_ms"] = tr.Duration
+	}
+	return l
+}
+
+/*submitListens - POST /1/submit-listens, retried (as server says or
+with growing wait) when server is rate limiting or failing
+*/
+func submitListens(ctx context.Context, server string, token string, listens []listen) error {
+	if len(listens) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"listen_type": "import",
+		"payload":     listens,
+	})
+	if err != nil {
+		return err
+	}
+	wait := time.Second
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := postListens(ctx, server, token, body)
+		if err == nil || err == errScrobbleToken || retryAfter < 0 || attempt == scrobbleAttempts {
+			return err
+		}
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		log.Printf("submitListens: %s - retrying in %s", err.Error(), wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		wait *= 2
+	}
+}
+
+/*postListens - single attempt. retryAfter is negative if there
+is no point in retrying, zero if server hasn't said how long to wait.
+*/
+func postListens(ctx context.Context, server string, token string, body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(server, "/")+"/1/submit-listens", bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Authorization", "Token "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := scrobbleClient.Do(req)
+	if errors.Is(err, errScrobbleURL) { // refused address or redirect
+		return -1, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return 0, nil
+	case resp.StatusCode == http.StatusUnauthorized:
+		return -1, errScrobbleToken
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		var retryAfter time.Duration
+		if s, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Reset-In")); err == nil {
+			retryAfter = time.Duration(s+1) * time.Second
+		}
+		return retryAfter, fmt.Errorf("scrobble: %s", resp.Status)
+	}
+	return -1, fmt.Errorf("scrobble: %s %s", resp.Status, strings.TrimSpace(string(msg)))
+}
+
+/*validateScrobbleToken - GET /1/validate-token, user name token belongs to
+ */
+func validateScrobbleToken(ctx context.Context, server string, token string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(server, "/")+"/1/validate-token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Token "+token)
+	resp, err := scrobbleClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Valid    bool   `json:"valid"`
+		UserName string `json:"user_name"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&result); err != nil {
+		return "", fmt.Errorf("scrobble: %s %v", resp.Status, err)
+	}
+	if !result.Valid {
+		return "", errScrobbleToken
+	}
+	return result.UserName, nil
+}
+
+/*checkScrobbleURL - errScrobbleURL unless server is https URL of a host
+which isn't an IP address outside the internet (names are checked when
+connecting, see publicOnly)
+*/
+func checkScrobbleURL(server string) error {
+	u, err := url.Parse(server)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" || u.User != nil {
+		return errScrobbleURL
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !publicAddress(ip) {
+		return errScrobbleURL
+	}
+	return nil
+}
+
+/*publicOnly - net.Dialer Control refusing connections to addresses
+which aren't on the internet (loopback, private, link-local...)
+*/
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+		return fmt.Errorf("%w (%s is not a public address)", errScrobbleURL, host)
+	}
+	return nil
+}
+
+// shared address space (carrier-grade NAT) isn't covered by net.IP.IsPrivate
+var sharedAddressSpace = &net.IPNet{IP: net.IP{100, 64, 0, 0}, Mask: net.CIDRMask(10, 32)}
+
+func publicAddress(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+/*saveIntegration - keeps token of another service (empty - none) in user
+document. Integrations are saved whole as bbolt doesn't merge nested fields.
+*/
+func saveIntegration(user string, name string, token string) error {
+	u, err := db.GetUser(user)
+	if err != nil && err != errNotFound {
+		return err
+	}
+	integrations := map[string]integrationToken{}
+	if u != nil {
+		for k, v := range u.Integrations {
+			integrations[k] = v
+		}
+	}
+	sealed := integrationToken{}
+	if token != "" {
+		if sealed, err = sealIntegration(tokenCipher, user, name, token); err != nil {
+			return err
+		}
+	}
+	integrations[name] = sealed
+	return db.UpdateUser(user, map[string]interface{}{"integrations": integrations})
+}
+
+/*scrobbleSettings - POST /scrobble turns scrobbling on (url, token)
+or off (off=1). Token is checked with server first. Only plays saved
+from now on are sent.
+*/
+func scrobbleSettings(c *gin.Context) {
+	user, _ := sessions.Default(c).Get("user").(string)
+	if c.PostForm("off") == "1" {
+		if err := saveIntegration(user, scrobbleIntegration, ""); err != nil {
+			log.Printf("/scrobble: %s", err.Error())
+		}
+		db.UpdateUser(user, map[string]interface{}{"scrobble_url": "", "scrobble_error": ""})
+		c.Redirect(http.StatusSeeOther, "/user")
+		return
+	}
+	server := strings.TrimSpace(c.DefaultPostForm("url", defaultScrobbleURL))
+	if server == "" {
+		server = defaultScrobbleURL
+	}
+	token := strings.TrimSpace(c.PostForm("token"))
+	if err := checkScrobbleURL(server); err != nil {
+		c.String(http.StatusBadRequest, "Server URL must be https URL of a public host")
+		return
+	}
+	name, err := validateScrobbleToken(c.Request.Context(), server, token)
+	if err != nil {
+		log.Printf("/scrobble: %s %s", server, err.Error())
+		c.String(http.StatusBadRequest, "Server didn't accept the token: %s", err.Error())
+		return
+	}
+	if err := saveIntegration(user, scrobbleIntegration, token); err != nil {
+		log.Printf("/scrobble: %s", err.Error())
+		c.String(http.StatusInternalServerError, "Failed to save token")
+		return
+	}
+	err = db.UpdateUser(user, map[string]interface{}{
+		"scrobble_url":     server,
+		"scrobble_user":    name,
+		"scrobble_cursor":  time.Now(),
+		"scrobble_error":   "",
+		"scrobble_updated": time.Now(),
+	})
+	if err != nil {
+		log.Printf("/scrobble: %s", err.Error())
+	}
+	c.Redirect(http.StatusSeeOther, "/user")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+/*scrobbleServer - stand-in ListenBrainz server answering submissions
+with statuses in turn (the last one repeats) and remembering how many
+listens each of them had
+*/
+type scrobbleServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	resetIn  string
+	batches  []int
+	auth     []string
+}
+
+func newScrobbleServer(t *testing.T, resetIn string, statuses ...int) *scrobbleServer {
+	s := &scrobbleServer{statuses: statuses, resetIn: resetIn}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Payload []listen `json:"payload"`
+		}
+		if r.URL.Path != "/1/submit-listens" || json.NewDecoder(r.Body).Decode(&body) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		status := s.statuses[0]
+		if len(s.statuses) > 1 {
+			s.statuses = s.statuses[1:]
+		}
+		s.batches = append(s.batches, len(body.Payload))
+		s.auth = append(s.auth, r.Header.Get("Authorization"))
+		s.mu.Unlock()
+		if s.resetIn != "" {
+			w.Header().Set("X-RateLimit-Reset-In", s.resetIn)
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+/*allowLoopback - lets scrobbleClient reach stand-in server
+ */
+func allowLoopback(t *testing.T) {
+	guard := scrobbleDialGuard
+	scrobbleDialGuard = func(string, string, syscall.RawConn) error { return nil }
+	t.Cleanup(func() { scrobbleDialGuard = guard })
+}
+
+func TestSubmitListens(t *testing.T) {
+	allowLoopback(t)
+	listens := []listen{newListen(firestoreTrack{Name: "a", Artists: "x", ID: "a", PlayedAt: time.Now()})}
+	tests := []struct {
+		name     string
+		resetIn  string
+		statuses []int
+		err      error // nil - accepted, errScrobbleToken or any other error
+		requests int
+		waited   time.Duration
+	}{
+		{"accepted", "", []int{http.StatusOK}, nil, 1, 0},
+		{"rate limited", "0", []int{http.StatusTooManyRequests, http.StatusOK}, nil, 2, time.Second},
+		{"server failing", "0", []int{http.StatusServiceUnavailable, http.StatusOK}, nil, 2, time.Second},
+		{"gives up", "0", []int{http.StatusInternalServerError}, fmt.Errorf("500"), scrobbleAttempts, 2 * time.Second},
+		{"token rejected", "", []int{http.StatusUnauthorized}, errScrobbleToken, 1, 0},
+		{"bad request isn't retried", "", []int{http.StatusBadRequest}, fmt.Errorf("400"), 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newScrobbleServer(t, tt.resetIn, tt.statuses...)
+			start := time.Now()
+			err := submitListens(context.Background(), server.URL, "secret", listens)
+			switch {
+			case tt.err == nil && err != nil:
+				t.Fatalf("submitListens() error = %v", err)
+			case tt.err == errScrobbleToken && err != errScrobbleToken:
+				t.Fatalf("submitListens() error = %v, want %v", err, errScrobbleToken)
+			case tt.err != nil && (err == nil || !strings.Contains(err.Error(), tt.err.Error())):
+				t.Fatalf("submitListens() error = %v, want %v", err, tt.err)
+			}
+			if len(server.batches) != tt.requests {
+				t.Errorf("submitListens() made %d requests, want %d", len(server.batches), tt.requests)
+			}
+			if waited := time.Since(start); waited < tt.waited {
+				t.Errorf("submitListens() waited %s, want at least %s", waited, tt.waited)
+			}
+			if server.auth[0] != "Token secret" {
+				t.Errorf("submitListens() Authorization = %q", server.auth[0])
+			}
+		})
+	}
+}
+
+func TestSubmitListensShutdown(t *testing.T) {
+	allowLoopback(t)
+	server := newScrobbleServer(t, "60", http.StatusTooManyRequests)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	listens := []listen{newListen(firestoreTrack{Name: "a", PlayedAt: time.Now()})}
+	if err := submitListens(ctx, server.URL, "secret", listens); err != context.DeadlineExceeded {
+		t.Errorf("submitListens() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestScrobbleLoopbackRefused(t *testing.T) {
+	server := newScrobbleServer(t, "", http.StatusOK)
+	listens := []listen{newListen(firestoreTrack{Name: "a", PlayedAt: time.Now()})}
+	err := submitListens(context.Background(), server.URL, "secret", listens)
+	if !errors.Is(err, errScrobbleURL) {
+		t.Errorf("submitListens() error = %v", err)
+	}
+	if len(server.batches) != 0 {
+		t.Errorf("submitListens() reached loopback server")
+	}
+}
+
+func TestScrobblePlays(t *testing.T) {
+	allowLoopback(t)
+	t0 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(i int) time.Time { return t0.Add(time.Duration(i) * time.Minute) }
+	tests := []struct {
+		name      string
+		statuses  []int
+		submitted int
+		batches   []int
+		cursor    time.Time // after run
+		failed    bool
+	}{
+		{"all batches accepted", []int{http.StatusOK}, 249, []int{100, 99, 50}, at(250), false},
+		{"cursor stops at failed batch", []int{http.StatusOK, http.StatusBadRequest}, 100, []int{100, 99}, at(100), true},
+		{"token rejected", []int{http.StatusUnauthorized}, 0, []int{100}, at(0), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := newBoltStore(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			saved := db
+			db = store
+			t.Cleanup(func() { db = saved })
+			server := newScrobbleServer(t, "", tt.statuses...)
+			token, err := sealIntegration(nil, "user", scrobbleIntegration, "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = store.UpdateUser("user", map[string]interface{}{
+				"scrobble_url":    server.URL,
+				"scrobble_cursor": at(0),
+				"integrations":    map[string]integrationToken{scrobbleIntegration: token},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			plays := []firestoreTrack{}
+			for i := 0; i <= 250; i++ { // the first one has been sent before
+				plays = append(plays, firestoreTrack{Name: "t", Artists: "a", ID: fmt.Sprint(i), PlayedAt: at(i), Skipped: i == 150})
+			}
+			if err := store.SaveRecentlyPlayed("user", plays); err != nil {
+				t.Fatal(err)
+			}
+			submitted, err := scrobblePlays(context.Background(), "user")
+			if (err != nil) != tt.failed {
+				t.Fatalf("scrobblePlays() error = %v", err)
+			}
+			if submitted != tt.submitted || fmt.Sprint(server.batches) != fmt.Sprint(tt.batches) {
+				t.Errorf("scrobblePlays() = %d in batches %v, want %d in %v", submitted, server.batches, tt.submitted, tt.batches)
+			}
+			u, err := store.GetUser("user")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !u.ScrobbleCursor.Equal(tt.cursor) || u.ScrobbleCount != tt.submitted || (u.ScrobbleError != "") != tt.failed {
+				t.Errorf("scrobblePlays() cursor %s count %d error %q, want %s %d", u.ScrobbleCursor, u.ScrobbleCount, u.ScrobbleError, tt.cursor, tt.submitted)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// how often we bother database with last seen time of a session
+const sessionTouchInterval = 5 * time.Minute
+
+/*recordSession - remembers browser user has just logged in with
+so user can see it (and sign it out) on /user page. Cookie is marked
+as recorded (caller saves session) so missing record means signed out.
+*/
+func recordSession(c *gin.Context, user string, uuid string) {
+	sessions.Default(c).Set("recorded", true)
+	now := time.Now()
+	if err := db.SaveSession(user, &userSession{
+		ID:        uuid,
+		UserAgent: c.Request.UserAgent(),
+		Created:   now,
+		LastSeen:  now,
+	}); err != nil {
+		log.Printf("recordSession: Error saving session of %s %s", user, err.Error())
+	}
+	shared.Set(user, "seen/"+uuid, true, sessionTouchInterval)
+}
+
+/*touchSession - updates last seen time of a session
+(no more often then sessionTouchInterval)
+*/
+func touchSession(user string, uuid string) {
+	var seen bool
+	if shared.Get(user, "seen/"+uuid, &seen) {
+		return
+	}
+	shared.Set(user, "seen/"+uuid, true, sessionTouchInterval)
+	us, err := db.GetSession(user, uuid)
+	if err != nil {
+		log.Printf("touchSession: Session %s of %s %s", uuid, user, err.Error())
+		return
+	}
+	us.LastSeen = time.Now()
+	if err := db.SaveSession(user, us); err != nil {
+		log.Printf("touchSession: Error saving session of %s %s", user, err.Error())
+	}
+}
+
+/*userSessions - active sessions of user, most recently used first.
+Sessions which cookies have already expired are removed on the way.
+*/
+func userSessions(user string, current string) []userSession {
+	list, err := db.Sessions(user)
+	if err != nil {
+		log.Printf("userSessions: Error retrieving sessions of %s %s", user, err.Error())
+		return nil
+	}
+	active := []userSession{}
+	for _, us := range list {
+		if time.Since(us.LastSeen) > sessionTimeout*time.Second {
+			signOutSession(user, us.ID)
+			continue
+		}
+		us.Current = us.ID == current
+		active = append(active, us)
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].LastSeen.After(active[j].LastSeen)
+	})
+	return active
+}
+
+/*signOutSession - forgets session and its cached Spotify client.
+Browser still has a cookie but AuthenticationRequired won't find
+the session anymore. Other instances learn about it from shared cache.
+*/
+func signOutSession(user string, uuid string) {
+	kaszka.Delete(uuid)
+	shared.Delete(user, "seen/"+uuid)
+	shared.Set(user, "signedout/"+uuid, true, sessionTimeout*time.Second)
+	if err := db.DeleteSession(user, uuid); err != nil {
+		log.Printf("signOutSession: Error deleting session %s of %s %s", uuid, user, err.Error())
+	}
+}
+
+/*signOutEverywhere - signs out all sessions of user
+ */
+func signOutEverywhere(user string) {
+	list, err := db.Sessions(user)
+	if err != nil {
+		log.Printf("signOutEverywhere: Error retrieving sessions of %s %s", user, err.Error())
+		return
+	}
+	for _, us := range list {
+		signOutSession(user, us.ID)
+	}
+}
+
+/*forgetTokens - deletes all Spotify tokens we keep for user.
+Background jobs stop working for user until user logs in again.
+*/
+func forgetTokens(user string) {
+	tokens, err := db.Tokens(user)
+	if err != nil {
+		log.Printf("forgetTokens: Error retrieving tokens of %s %s", user, err.Error())
+		return
+	}
+	for path := range tokens {
+		shared.Delete(user, "token"+path)
+		if err := db.DeleteToken(user, path); err != nil {
+			log.Printf("forgetTokens: Error deleting token %s of %s %s", path, user, err.Error())
+		}
+	}
+	log.Printf("forgetTokens: Tokens of %s deleted", user)
+}
+
+/*sessionSignedOut - true if session has been signed out
+(perhaps on another instance which had its client cached)
+*/
+func sessionSignedOut(user string, uuid string) bool {
+	var out bool
+	return shared.Get(user, "signedout/"+uuid, &out)
+}
+
+/*signedOut - session has been signed out (from another device)
+so we clear the cookie and send user to home page
+*/
+func signedOut(c *gin.Context) {
+	session := sessions.Default(c)
+	session.Clear()
+	session.Save()
+	log.Printf("signedOut: %s", c.Request.URL.Path)
+	c.Redirect(http.StatusSeeOther, "/")
+	c.Abort()
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const statsTop = 10 // artists and tracks shown
+
+// periods /stats can be asked for, in days (0 - whole stored history)
+var statsPeriods = map[string]int{"7d": 7, "30d": 30, "90d": 90, "365d": 365, "all": 0}
+
+/*listeningStats - what /stats shows (and returns as JSON). Days and
+hours are in user's timezone, skipped plays aren't counted as listens.
+*/
+type listeningStats struct {
+	Timezone      string       `json:"timezone"`
+	Period        string       `json:"period"`
+	From          string       `json:"from"` // YYYY-MM-DD
+	To            string       `json:"to"`
+	Listens       int          `json:"listens"`
+	Skips         int          `json:"skips"`
+	Minutes       float64      `json:"minutes"`
+	Days          []dayMinutes `json:"days"`
+	Heatmap       [7][24]int   `json:"heatmap"` // listens by weekday (Sunday first) and hour
+	TopArtists    []statsItem  `json:"top_artists"`
+	TopTracks     []statsItem  `json:"top_tracks"`
+	NewListens    int          `json:"new_listens"` // first listen of track ever
+	RepeatListens int          `json:"repeat_listens"`
+	NewRatio      float64      `json:"new_ratio"`
+	Streak        listenStreak `json:"longest_streak"`
+	// plays older than retention are counted from monthly rollups (whole
+	// UTC months within period) - days, heatmap, streak and new/repeat
+	// listens cover only plays kept from Retained on
+	RolledUp   []string `json:"rolled_up_months,omitempty"` // YYYY-MM
+	Retained   string   `json:"retained_from,omitempty"`    // YYYY-MM-DD
+	heatmapMax int      // for shading cells
+	daysMax    float64  // for bar widths
+}
+
+type dayMinutes struct {
+	Day     string  `json:"day"`
+	Minutes float64 `json:"minutes"`
+}
+
+type statsItem struct {
+	ID      string `json:"id,omitempty"`
+	Name    string `json:"name"`
+	Artists string `json:"artists,omitempty"`
+	Count   int    `json:"count"`
+}
+
+/*listenStreak - longest run of consecutive days with a listen
+ */
+type listenStreak struct {
+	Days  int    `json:"days"`
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+/*stats - listening statistics of chosen period computed from stored plays
+/stats?period=30d&tz=Europe/Warsaw[&format=json]
+Timezone (from browser) is remembered so JSON clients can leave it out.
+*/
+func stats(c *gin.Context) {
+	user, _ := sessions.Default(c).Get("user").(string)
+	period := c.DefaultQuery("period", "30d")
+	days, ok := statsPeriods[period]
+	if !ok {
+		c.String(http.StatusBadRequest, "Unknown period %s (7d, 30d, 90d, 365d or all)", period)
+		return
+	}
+	location := userTimezone(user, c.Query("tz"))
+	now := time.Now().In(location)
+	to := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, location)
+	var from time.Time
+	if days > 0 {
+		from = to.AddDate(0, 0, -days)
+	}
+	s, err := computeStats(user, from, to, location)
+	if err != nil {
+		log.Printf("/stats: %s %s", user, err.Error())
+		c.String(http.StatusInternalServerError, "Failed to compute statistics")
+		return
+	}
+	s.Period = period
+	if c.Query("format") == "json" {
+		c.JSON(http.StatusOK, s)
+		return
+	}
+	c.HTML(
+		http.StatusOK,
+		"stats.html",
+		gin.H{
+			"Stats":   s,
+			"Days":    s.dayBars(),
+			"Heatmap": s.heatmapCells(),
+			"Hours":   hoursOfDay(),
+			"Periods": []string{"7d", "30d", "90d", "365d", "all"},
+			"title":   "Statistics",
+		},
+	)
+}
+
+/*userTimezone - tz if it is a valid timezone (and remembers it for user),
+otherwise timezone user has been seen with, otherwise app timezone
+*/
+func userTimezone(user string, tz string) *time.Location {
+	u, err := db.GetUser(user)
+	if err != nil {
+		log.Printf("userTimezone: %s %s", user, err.Error())
+		u = &firestoreUser{}
+	}
+	if tz != "" {
+		if location, err := time.LoadLocation(tz); err == nil {
+			if tz != u.Timezone {
+				if err := db.UpdateUser(user, map[string]interface{}{"timezone": tz}); err != nil {
+					log.Printf("userTimezone: %s %s", user, err.Error())
+				}
+			}
+			return location
+		}
+	}
+	if u.Timezone != "" {
+		if location, err := time.LoadLocation(u.Timezone); err == nil {
+			return location
+		}
+	}
+	return cfg.Location
+}
+
+/*computeStats - walks user's plays once. Plays before from are only
+looked at to tell new tracks from repeats. Months rolled up by retention
+are added to totals and top artists and tracks.
+*/
+func computeStats(user string, from, to time.Time, location *time.Location) (*listeningStats, error) {
+	s := &listeningStats{Timezone: location.String()}
+	rollups, err := db.Rollups(user)
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]bool{} // tracks listened to before
+	for _, r := range rollups {
+		for id := range r.Tracks { // purged plays are older than any kept one
+			known[id] = true
+		}
+	}
+	names := map[string]statsItem{} // of tracks in plays kept
+	minutes := map[string]float64{}
+	listenDays := map[string]bool{}
+	artists := map[string]int{}
+	tracks := map[string]*statsItem{}
+	first, oldest := time.Time{}, time.Time{}
+	err = db.EachPlay(user, time.Time{}, to, func(tr firestoreTrack) error {
+		if oldest.IsZero() {
+			oldest = tr.PlayedAt
+		}
+		if _, ok := names[tr.ID]; !ok && tr.ID != "" {
+			names[tr.ID] = statsItem{ID: tr.ID, Name: tr.Name, Artists: tr.Artists}
+		}
+		if tr.Skipped {
+			if !tr.PlayedAt.Before(from) {
+				s.Skips++
+			}
+			return nil
+		}
+		isNew := tr.ID != "" && !known[tr.ID]
+		known[tr.ID] = true
+		if tr.PlayedAt.Before(from) {
+			return nil
+		}
+		if first.IsZero() {
+			first = tr.PlayedAt
+		}
+		local := tr.PlayedAt.In(location)
+		day := local.Format("2006-01-02")
+		m := float64(tr.Duration) / float64(time.Minute/time.Millisecond)
+		s.Listens++
+		s.Minutes += m
+		minutes[day] += m
+		listenDays[day] = true
+		s.Heatmap[local.Weekday()][local.Hour()]++
+		if isNew {
+			s.NewListens++
+		} else {
+			s.RepeatListens++
+		}
+		for _, artist := range splitArtists(tr.Artists) {
+			artists[artist]++
+		}
+		if tr.ID != "" {
+			t, ok := tracks[tr.ID]
+			if !ok {
+				t = &statsItem{ID: tr.ID, Name: tr.Name, Artists: tr.Artists}
+				tracks[tr.ID] = t
+			}
+			t.Count++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rollups {
+		start, err := time.Parse("2006-01", r.Month)
+		if err != nil || start.Before(from) || start.AddDate(0, 1, 0).After(to) {
+			continue
+		}
+		s.RolledUp = append(s.RolledUp, r.Month)
+		s.Listens += r.Plays
+		s.Skips += r.Skips
+		s.Minutes += r.Minutes
+		for name, n := range r.Artists {
+			artists[name] += n
+		}
+		for id, n := range r.Tracks {
+			t, ok := tracks[id]
+			if !ok {
+				item := names[id]
+				item.ID = id
+				t = &item
+				tracks[id] = t
+			}
+			t.Count += n
+		}
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+	}
+	if len(s.RolledUp) > 0 && !oldest.IsZero() {
+		s.Retained = oldest.In(location).Format("2006-01-02")
+	}
+	if from.IsZero() { // all - from first listen
+		from = first
+		if from.IsZero() {
+			from = to.AddDate(0, 0, -1)
+		}
+	}
+	from = from.In(location)
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, location)
+	s.From = from.Format("2006-01-02")
+	s.To = to.AddDate(0, 0, -1).Format("2006-01-02")
+	// every day of period, also these without listens
+	streak := listenStreak{}
+	for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+		day := d.Format("2006-01-02")
+		s.Days = append(s.Days, dayMinutes{Day: day, Minutes: minutes[day]})
+		if minutes[day] > s.daysMax {
+			s.daysMax = minutes[day]
+		}
+		if !listenDays[day] {
+			streak = listenStreak{}
+			continue
+		}
+		if streak.Days == 0 {
+			streak.Start = day
+		}
+		streak.Days++
+		streak.End = day
+		if streak.Days > s.Streak.Days {
+			s.Streak = streak
+		}
+	}
+	for _, row := range s.Heatmap {
+		for _, n := range row {
+			if n > s.heatmapMax {
+				s.heatmapMax = n
+			}
+		}
+	}
+	if s.Listens > 0 {
+		s.NewRatio = float64(s.NewListens) / float64(s.Listens)
+	}
+	for name, n := range artists {
+		s.TopArtists = append(s.TopArtists, statsItem{Name: name, Count: n})
+	}
+	for _, t := range tracks {
+		s.TopTracks = append(s.TopTracks, *t)
+	}
+	s.TopArtists = topItems(s.TopArtists)
+	s.TopTracks = topItems(s.TopTracks)
+	return s, nil
+}
+
+/*topItems - statsTop most counted, ties by name
+ */
+func topItems(items []statsItem) []statsItem {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count == items[j].Count {
+			return items[i].Name < items[j].Name
+		}
+		return items[i].Count > items[j].Count
+	})
+	if len(items) > statsTop {
+		items = items[:statsTop]
+	}
+	return items
+}
+
+// dayBar - minutes of a day with bar width (percent of busiest day)
+type dayBar struct {
+	Day     string
+	Minutes int
+	Width   int
+}
+
+func (s *listeningStats) dayBars() []dayBar {
+	bars := []dayBar{}
+	for _, d := range s.Days {
+		bar := dayBar{Day: d.Day, Minutes: int(d.Minutes + 0.5)}
+		if s.daysMax > 0 {
+			bar.Width = int(100 * d.Minutes / s.daysMax)
+		}
+		bars = append(bars, bar)
+	}
+	return bars
+}
+
+// heatmapRow - listens of weekday by hour with cell shading
+type heatmapRow struct {
+	Weekday string
+	Cells   []heatmapCell
+}
+
+type heatmapCell struct {
+	Count int
+	Alpha string // CSS background opacity
+}
+
+/*heatmapCells - rows from Monday to Sunday
+ */
+func (s *listeningStats) heatmapCells() []heatmapRow {
+	rows := []heatmapRow{}
+	for i := 1; i <= 7; i++ {
+		wd := time.Weekday(i % 7)
+		row := heatmapRow{Weekday: wd.String()[:3]}
+		for _, n := range s.Heatmap[wd] {
+			cell := heatmapCell{Count: n, Alpha: "0"}
+			if s.heatmapMax > 0 {
+				cell.Alpha = fmt.Sprintf("%.2f", float64(n)/float64(s.heatmapMax))
+			}
+			row.Cells = append(row.Cells, cell)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func hoursOfDay() []int {
+	hours := make([]int, 24)
+	for i := range hours {
+		hours[i] = i
+	}
+	return hours
+}
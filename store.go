@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"go-spotify/common/plays"
+	"go-spotify/common/tokenkeys"
+	"golang.org/x/oauth2"
+)
+
+// errNotFound is returned by Store when requested record doesn't exist
+var errNotFound = errors.New("store: record not found")
+
+/*Store - is everything the app persists. Tokens, users, browser sessions, recently played
+tracks and popularity counters. Firestore is used in the cloud, embedded
+bbolt database lets you run the whole app locally without GCP credentials.
+*/
+type Store interface {
+	// GetToken - Spotify token for user and authorization path
+	GetToken(user string, path string) (*storedToken, error)
+	// SaveToken - saves Spotify token for user and authorization path
+	// (granted scopes are kept if token.Scopes is nil)
+	SaveToken(user string, path string, token *storedToken) error
+	// Tokens - all tokens of user keyed by authorization path
+	Tokens(user string) (map[string]*storedToken, error)
+	// DeleteToken - removes Spotify token for user and authorization path
+	DeleteToken(user string, path string) error
+	// GetUser - user document
+	GetUser(user string) (*firestoreUser, error)
+	// UpdateUser - merges fields into user document (creates if missing)
+	UpdateUser(user string, fields map[string]interface{}) error
+	// Users - all users
+	Users() ([]firestoreUser, error)
+	// GetSession - browser session of user
+	GetSession(user string, id string) (*userSession, error)
+	// SaveSession - saves (or replaces) browser session of user
+	SaveSession(user string, session *userSession) error
+	// Sessions - all browser sessions of user
+	Sessions(user string) ([]userSession, error)
+	// DeleteSession - removes browser session of user
+	DeleteSession(user string, id string) error
+	// SaveRecentlyPlayed - saves plays into user's history (one record
+	// per play, saving the same play again changes nothing)
+	SaveRecentlyPlayed(user string, tracks []firestoreTrack) error
+	// RecentlyPlayed - user's plays, most recent first
+	RecentlyPlayed(user string, q historyQuery) ([]firestoreTrack, error)
+	// MigratePlays - copies legacy history (one record per track)
+	// into plays without counting them again
+	MigratePlays(user string) (int, error)
+	// PopularTracks - most played tracks in window ("7d", "30d" or all
+	// time if empty), most popular first
+	PopularTracks(user string, window string, limit int) ([]popularTrack, error)
+	// PurgePlays - adds plays older than before to monthly rollups
+	// and deletes them, returns number of plays deleted
+	PurgePlays(user string, before time.Time) (int, error)
+	// Rollups - monthly summaries of purged plays, oldest month first
+	Rollups(user string) ([]monthlyRollup, error)
+	// EachPlay - calls fn for user's plays from (inclusive) to (exclusive),
+	// oldest first, reading them as it goes. Zero time means no limit.
+	EachPlay(user string, from time.Time, to time.Time, fn func(firestoreTrack) error) error
+	// TrackFeatures - stored audio features of tracks (missing ones are left out)
+	TrackFeatures(ids []string) (map[string]trackFeatures, error)
+	// SaveTrackFeatures - keeps audio features of tracks
+	SaveTrackFeatures(features []trackFeatures) error
+	// WithContext - the same store whose calls give up when ctx is done
+	// (background work uses it so Shutdown isn't kept waiting)
+	WithContext(ctx context.Context) Store
+	// Close - releases database
+	Close() error
+}
+
+/*playID - key of a play, played_at (ms, sortable) plus track ID
+so the same track played twice makes two records
+*/
+func playID(tr firestoreTrack) string {
+	return plays.ID(tr.PlayedAt, tr.ID)
+}
+
+// popularity windows - days including today (UTC). CloudCounter counts
+// listens in them, MidnightRun subtracts days which have left them.
+var popularWindows = map[string]int{"7d": 7, "30d": 30}
+
+/*windowStart - first day (UTC, YYYY-MM-DD) of window of days ending today
+ */
+func windowStart(now time.Time, days int) string {
+	return now.UTC().AddDate(0, 0, 1-days).Format("2006-01-02")
+}
+
+/*historyQuery - describes a page of recently played tracks
+Before (if set) takes precedence over Offset
+*/
+type historyQuery struct {
+	Limit  int
+	Offset int
+	Before time.Time
+}
+
+/*initStore - creates Store selected by STORE_BACKEND
+(firestore by default or bolt for local database)
+with tokens encrypted with keys from TOKEN_KEYS
+*/
+func initStore(ctx context.Context) *encryptedStore {
+	keys, err := tokenkeys.Load(cfg.TokenKeys, cfg.TokenKeyID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if keys == nil {
+		log.Println("TOKEN_KEYS not set - Spotify tokens are stored in plaintext")
+	}
+	tokenCipher = keys
+	return &encryptedStore{Store: openStore(ctx), keys: keys}
+}
+
+/*openStore - creates database backend
+ */
+func openStore(ctx context.Context) Store {
+	switch cfg.StoreBackend {
+	case "bolt":
+		path := cfg.BoltPath
+		if path == "" {
+			path = "go-spotify.db"
+		}
+		store, err := newBoltStore(path)
+		if err != nil {
+			log.Panic(err)
+		}
+		log.Printf("BOLT: %s", path)
+		return store
+	case "", "firestore":
+		return &firestoreStore{client: initFirestoreDatabase(ctx), ctx: ctx}
+	default:
+		log.Panicf("Unknown STORE_BACKEND %s", cfg.StoreBackend)
+	}
+	return nil
+}
+
+/*newStoredToken - token as we keep it in database
+ */
+func newStoredToken(tok *oauth2.Token, scopes []string) *storedToken {
+	return &storedToken{
+		AccessToken:  tok.AccessToken,
+		TokenType:    tok.TokenType,
+		RefreshToken: tok.RefreshToken,
+		Expiry:       tok.Expiry,
+		Scopes:       scopes,
+	}
+}
+
+/*oauth2Token - token as Spotify client needs it
+ */
+func (t *storedToken) oauth2Token() *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  t.AccessToken,
+		TokenType:    t.TokenType,
+		RefreshToken: t.RefreshToken,
+		Expiry:       t.Expiry,
+	}
+}
+
+/*grantedScopes - scopes Spotify has granted with a token
+(returned in token response, nil if Spotify didn't tell)
+*/
+func grantedScopes(tok *oauth2.Token) []string {
+	if scope, ok := tok.Extra("scope").(string); ok {
+		return strings.Fields(scope)
+	}
+	return nil
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	usersBucket          = []byte("users")
+	tokensBucket         = []byte("tokens")
+	sessionsBucket       = []byte("sessions")
+	recentlyPlayedBucket = []byte("recently_played") // legacy, see MigratePlays
+	playsBucket          = []byte("plays")
+	popularTracksBucket  = []byte("popular_tracks")
+	rollupsBucket        = []byte("rollups")
+	featuresBucket       = []byte("audio_features")
+	popularDaysBucket    = []byte("popular_days")
+)
+
+/*boltStore - Store kept in embedded bbolt database (single file)
+It mirrors Firestore layout: users, tokens and sessions are top level
+buckets (keyed by user and path or user/uuid),
+plays, popular_tracks, popular_days and rollups have nested bucket per user.
+audio_features are shared by all users. Values are JSON. As there is no CloudCounter here popularity
+counters are incremented when a new play is saved. Listens of recent days
+are kept in popular_days so windowed counts are summed up when asked for.
+*/
+type boltStore struct {
+	db  *bolt.DB
+	ctx context.Context // bolt can't cancel a transaction, see view
+}
+
+func newBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{usersBucket, tokensBucket, sessionsBucket, recentlyPlayedBucket, playsBucket, popularTracksBucket, rollupsBucket, featuresBucket, popularDaysBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db, ctx: context.Background()}, nil
+}
+
+func (s *boltStore) WithContext(ctx context.Context) Store {
+	return &boltStore{db: s.db, ctx: ctx}
+}
+
+/*view - read-only transaction unless ctx is done already
+ */
+func (s *boltStore) view(fn func(*bolt.Tx) error) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.View(fn)
+}
+
+/*update - read-write transaction unless ctx is done already
+ */
+func (s *boltStore) update(fn func(*bolt.Tx) error) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(fn)
+}
+
+func (s *boltStore) GetToken(user string, path string) (*storedToken, error) {
+	tok := &storedToken{}
+	err := s.view(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(tokensBucket), user+path, tok)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+func (s *boltStore) SaveToken(user string, path string, token *storedToken) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tokensBucket)
+		if token.Scopes == nil {
+			var stored storedToken
+			if err := getJSON(b, user+path, &stored); err == nil {
+				t := *token
+				t.Scopes = stored.Scopes
+				token = &t
+			}
+		}
+		return putJSON(b, user+path, token)
+	})
+}
+
+func (s *boltStore) Tokens(user string) (map[string]*storedToken, error) {
+	tokens := map[string]*storedToken{}
+	err := s.view(func(tx *bolt.Tx) error {
+		c := tx.Bucket(tokensBucket).Cursor()
+		prefix := []byte(user + "/")
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			tok := &storedToken{}
+			if err := json.Unmarshal(v, tok); err != nil {
+				return err
+			}
+			tokens[strings.TrimPrefix(string(k), user)] = tok
+		}
+		return nil
+	})
+	return tokens, err
+}
+
+func (s *boltStore) DeleteToken(user string, path string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokensBucket).Delete([]byte(user + path))
+	})
+}
+
+func (s *boltStore) GetUser(user string) (*firestoreUser, error) {
+	var u firestoreUser
+	err := s.view(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(usersBucket), user, &u)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *boltStore) UpdateUser(user string, fields map[string]interface{}) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		doc := map[string]interface{}{}
+		if err := getJSON(b, user, &doc); err != nil && err != errNotFound {
+			return err
+		}
+		for k, v := range fields {
+			doc[k] = v
+		}
+		return putJSON(b, user, doc)
+	})
+}
+
+func (s *boltStore) Users() ([]firestoreUser, error) {
+	users := []firestoreUser{}
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+			var u firestoreUser
+			if err := json.Unmarshal(v, &u); err != nil {
+				return err
+			}
+			if u.ID == "" {
+				u.ID = string(k)
+			}
+			users = append(users, u)
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (s *boltStore) GetSession(user string, id string) (*userSession, error) {
+	var us userSession
+	err := s.view(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(sessionsBucket), user+"/"+id, &us)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &us, nil
+}
+
+func (s *boltStore) SaveSession(user string, session *userSession) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(sessionsBucket), user+"/"+session.ID, session)
+	})
+}
+
+func (s *boltStore) Sessions(user string) ([]userSession, error) {
+	list := []userSession{}
+	err := s.view(func(tx *bolt.Tx) error {
+		c := tx.Bucket(sessionsBucket).Cursor()
+		prefix := []byte(user + "/")
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var us userSession
+			if err := json.Unmarshal(v, &us); err != nil {
+				return err
+			}
+			list = append(list, us)
+		}
+		return nil
+	})
+	return list, err
+}
+
+func (s *boltStore) DeleteSession(user string, id string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Delete([]byte(user + "/" + id))
+	})
+}
+
+func (s *boltStore) SaveRecentlyPlayed(user string, tracks []firestoreTrack) error {
+	return s.update(func(tx *bolt.Tx) error {
+		played, err := tx.Bucket(playsBucket).CreateBucketIfNotExists([]byte(user))
+		if err != nil {
+			return err
+		}
+		popular, err := tx.Bucket(popularTracksBucket).CreateBucketIfNotExists([]byte(user))
+		if err != nil {
+			return err
+		}
+		days, err := tx.Bucket(popularDaysBucket).CreateBucketIfNotExists([]byte(user))
+		if err != nil {
+			return err
+		}
+		oldest := windowStart(time.Now(), popularWindows["30d"])
+		for _, tr := range tracks {
+			key := playID(tr)
+			if played.Get([]byte(key)) != nil {
+				continue // same as CloudCounter - only new plays count
+			}
+			if err := putJSON(played, key, tr); err != nil {
+				return err
+			}
+			var pt popularTrack
+			if err := getJSON(popular, tr.ID, &pt); err != nil && err != errNotFound {
+				return err
+			}
+			if tr.Skipped {
+				pt.Skips++
+			} else {
+				pt.Count++
+			}
+			if err := putJSON(popular, tr.ID, pt); err != nil {
+				return err
+			}
+			if day := tr.PlayedAt.UTC().Format("2006-01-02"); !tr.Skipped && day >= oldest {
+				listens := map[string]int{}
+				if err := getJSON(days, day, &listens); err != nil && err != errNotFound {
+					return err
+				}
+				listens[tr.ID]++
+				if err := putJSON(days, day, listens); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) RecentlyPlayed(user string, q historyQuery) ([]firestoreTrack, error) {
+	tracks := []firestoreTrack{}
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(playsBucket).Bucket([]byte(user))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		// keys start with played_at so walking backwards gives most recent first
+		k, v := c.Last()
+		if !q.Before.IsZero() {
+			prefix := fmt.Sprintf("%013d", q.Before.UnixNano()/int64(time.Millisecond))
+			if k, v = c.Seek([]byte(prefix)); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		}
+		for skip := 0; k != nil; k, v = c.Prev() {
+			if q.Before.IsZero() && skip < q.Offset {
+				skip++
+				continue
+			}
+			var tr firestoreTrack
+			if err := json.Unmarshal(v, &tr); err != nil {
+				return err
+			}
+			tracks = append(tracks, tr)
+			if q.Limit > 0 && len(tracks) == q.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return tracks, err
+}
+
+func (s *boltStore) MigratePlays(user string) (int, error) {
+	n := 0
+	err := s.update(func(tx *bolt.Tx) error {
+		legacy := tx.Bucket(recentlyPlayedBucket).Bucket([]byte(user))
+		if legacy == nil {
+			return nil
+		}
+		played, err := tx.Bucket(playsBucket).CreateBucketIfNotExists([]byte(user))
+		if err != nil {
+			return err
+		}
+		return legacy.ForEach(func(k, v []byte) error {
+			var tr firestoreTrack
+			if err := json.Unmarshal(v, &tr); err != nil {
+				return err
+			}
+			if tr.ID == "" {
+				tr.ID = string(k)
+			}
+			n++
+			return putJSON(played, playID(tr), tr)
+		})
+	})
+	return n, err
+}
+
+func (s *boltStore) PopularTracks(user string, window string, limit int) ([]popularTrack, error) {
+	tracks := []popularTrack{}
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(popularTracksBucket).Bucket([]byte(user))
+		if b == nil {
+			return nil
+		}
+		days, ok := popularWindows[window]
+		if !ok {
+			return b.ForEach(func(k, v []byte) error {
+				var pt popularTrack
+				if err := json.Unmarshal(v, &pt); err != nil {
+					return err
+				}
+				pt.ID = string(k)
+				tracks = append(tracks, pt)
+				return nil
+			})
+		}
+		// sum up listens of days in window
+		listens := map[string]int{}
+		if d := tx.Bucket(popularDaysBucket).Bucket([]byte(user)); d != nil {
+			c := d.Cursor()
+			for k, v := c.Seek([]byte(windowStart(time.Now(), days))); k != nil; k, v = c.Next() {
+				day := map[string]int{}
+				if err := json.Unmarshal(v, &day); err != nil {
+					return err
+				}
+				for id, n := range day {
+					listens[id] += n
+				}
+			}
+		}
+		for id, n := range listens {
+			var pt popularTrack
+			if err := getJSON(b, id, &pt); err != nil && err != errNotFound {
+				return err
+			}
+			pt.ID = id
+			if window == "7d" {
+				pt.Count7d = n
+			} else {
+				pt.Count30d = n
+			}
+			tracks = append(tracks, pt)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(tracks, func(i, j int) bool {
+		if tracks[i].windowCount(window) == tracks[j].windowCount(window) {
+			return tracks[i].ID < tracks[j].ID // map order is random
+		}
+		return tracks[i].windowCount(window) > tracks[j].windowCount(window)
+	})
+	if limit > 0 && len(tracks) > limit {
+		tracks = tracks[:limit]
+	}
+	return tracks, nil
+}
+
+func (s *boltStore) PurgePlays(user string, before time.Time) (int, error) {
+	n := 0
+	err := s.update(func(tx *bolt.Tx) error {
+		played := tx.Bucket(playsBucket).Bucket([]byte(user))
+		if played == nil {
+			return nil
+		}
+		rollups, err := tx.Bucket(rollupsBucket).CreateBucketIfNotExists([]byte(user))
+		if err != nil {
+			return err
+		}
+		// keys start with played_at so old plays come first
+		limit := []byte(fmt.Sprintf("%013d", before.UnixNano()/int64(time.Millisecond)))
+		keys := [][]byte{}
+		tracks := []firestoreTrack{}
+		c := played.Cursor()
+		for k, v := c.First(); k != nil && bytes.Compare(k, limit) < 0; k, v = c.Next() {
+			var tr firestoreTrack
+			if err := json.Unmarshal(v, &tr); err != nil {
+				return err
+			}
+			keys = append(keys, k)
+			tracks = append(tracks, tr)
+		}
+		for month, r := range rollupPlays(tracks) {
+			var saved monthlyRollup
+			if err := getJSON(rollups, month, &saved); err != nil && err != errNotFound {
+				return err
+			}
+			r.merge(saved)
+			if err := putJSON(rollups, month, r); err != nil {
+				return err
+			}
+		}
+		// deleting while walking the cursor would skip keys
+		for _, k := range keys {
+			if err := played.Delete(k); err != nil {
+				return err
+			}
+		}
+		// days which have left the longest popularity window
+		if days := tx.Bucket(popularDaysBucket).Bucket([]byte(user)); days != nil {
+			oldest := []byte(windowStart(time.Now(), popularWindows["30d"]))
+			old := [][]byte{}
+			c := days.Cursor()
+			for k, _ := c.First(); k != nil && bytes.Compare(k, oldest) < 0; k, _ = c.Next() {
+				old = append(old, k)
+			}
+			for _, k := range old {
+				if err := days.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		n = len(keys)
+		return nil
+	})
+	return n, err
+}
+
+func (s *boltStore) Rollups(user string) ([]monthlyRollup, error) {
+	rollups := []monthlyRollup{}
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(rollupsBucket).Bucket([]byte(user))
+		if b == nil {
+			return nil
+		}
+		// keys are YYYY-MM so they are in order
+		return b.ForEach(func(k, v []byte) error {
+			var r monthlyRollup
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			rollups = append(rollups, r)
+			return nil
+		})
+	})
+	return rollups, err
+}
+
+func (s *boltStore) EachPlay(user string, from time.Time, to time.Time, fn func(firestoreTrack) error) error {
+	return s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(playsBucket).Bucket([]byte(user))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		k, v := c.First()
+		if !from.IsZero() {
+			k, v = c.Seek([]byte(fmt.Sprintf("%013d", from.UnixNano()/int64(time.Millisecond))))
+		}
+		var limit []byte
+		if !to.IsZero() {
+			limit = []byte(fmt.Sprintf("%013d", to.UnixNano()/int64(time.Millisecond)))
+		}
+		for ; k != nil && (limit == nil || bytes.Compare(k, limit) < 0); k, v = c.Next() {
+			if err := s.ctx.Err(); err != nil {
+				return err
+			}
+			var tr firestoreTrack
+			if err := json.Unmarshal(v, &tr); err != nil {
+				return err
+			}
+			if err := fn(tr); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) TrackFeatures(ids []string) (map[string]trackFeatures, error) {
+	features := map[string]trackFeatures{}
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(featuresBucket)
+		for _, id := range ids {
+			var f trackFeatures
+			if err := getJSON(b, id, &f); err == errNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			f.ID = id
+			features[id] = f
+		}
+		return nil
+	})
+	return features, err
+}
+
+func (s *boltStore) SaveTrackFeatures(features []trackFeatures) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(featuresBucket)
+		for _, f := range features {
+			if err := putJSON(b, f.ID, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+/*getJSON - reads and decodes value stored under key
+ */
+func getJSON(b *bolt.Bucket, key string, v interface{}) error {
+	data := b.Get([]byte(key))
+	if data == nil {
+		return errNotFound
+	}
+	return json.Unmarshal(data, v)
+}
+
+/*putJSON - encodes and stores value under key
+ */
+func putJSON(b *bolt.Bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), data)
+}
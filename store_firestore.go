@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*firestoreStore - Store kept in Cloud Firestore
+users/{userID} - user document
+users/{userID}/tokens/{path} - Spotify tokens
+users/{userID}/sessions/{uuid} - browser sessions
+users/{userID}/plays/{playedAtMs_trackID} - history (written by CloudRecent)
+users/{userID}/popular_tracks/{trackID} - counters (written by CloudCounter
+when play is created)
+users/{userID}/popular_days/{YYYY-MM-DD} - listens of a day still in popularity
+windows (written by CloudCounter, expired by MidnightRun)
+users/{userID}/rollups/{YYYY-MM} - monthly summaries of purged plays
+users/{userID}/recently_played/{trackID} - legacy history, see MigratePlays
+audio_features/{trackID} - audio features of tracks (shared by users)
+*/
+type firestoreStore struct {
+	client *firestore.Client
+	ctx    context.Context
+}
+
+func (s *firestoreStore) WithContext(ctx context.Context) Store {
+	return &firestoreStore{client: s.client, ctx: ctx}
+}
+
+func (s *firestoreStore) GetToken(user string, path string) (*storedToken, error) {
+	dsnap, err := s.client.Doc(fmt.Sprintf("users/%s/tokens%s", user, path)).Get(s.ctx)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	tok := &storedToken{}
+	if err := dsnap.DataTo(tok); err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+func (s *firestoreStore) SaveToken(user string, path string, token *storedToken) error {
+	fields := map[string]interface{}{
+		"AccessToken":  token.AccessToken,
+		"Expiry":       token.Expiry,
+		"RefreshToken": token.RefreshToken,
+		"TokenType":    token.TokenType,
+		"key_id":       token.KeyID,
+		"wrapped_key":  token.WrappedKey,
+	}
+	if token.Scopes != nil {
+		fields["scopes"] = token.Scopes
+	}
+	_, err := s.client.Doc(fmt.Sprintf("users/%s/tokens%s", user, path)).Set(s.ctx, fields, firestore.MergeAll)
+	return err
+}
+
+func (s *firestoreStore) Tokens(user string) (map[string]*storedToken, error) {
+	docs, err := s.client.Collection(fmt.Sprintf("users/%s/tokens", user)).Documents(s.ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	tokens := map[string]*storedToken{}
+	for _, doc := range docs {
+		tok := &storedToken{}
+		if err := doc.DataTo(tok); err != nil {
+			log.Printf("Tokens: skipping %s %s", doc.Ref.Path, err.Error())
+			continue
+		}
+		tokens["/"+doc.Ref.ID] = tok
+	}
+	return tokens, nil
+}
+
+func (s *firestoreStore) DeleteToken(user string, path string) error {
+	_, err := s.client.Doc(fmt.Sprintf("users/%s/tokens%s", user, path)).Delete(s.ctx)
+	return err
+}
+
+func (s *firestoreStore) GetUser(user string) (*firestoreUser, error) {
+	dsnap, err := s.client.Collection("users").Doc(user).Get(s.ctx)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	var u firestoreUser
+	if err := dsnap.DataTo(&u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *firestoreStore) UpdateUser(user string, fields map[string]interface{}) error {
+	_, err := s.client.Collection("users").Doc(user).Set(s.ctx, fields, firestore.MergeAll)
+	return err
+}
+
+func (s *firestoreStore) Users() ([]firestoreUser, error) {
+	docs, err := s.client.Collection("users").Documents(s.ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	users := []firestoreUser{}
+	for _, doc := range docs {
+		var u firestoreUser
+		if err := doc.DataTo(&u); err != nil {
+			log.Printf("Users: skipping %s %s", doc.Ref.ID, err.Error())
+			continue
+		}
+		if u.ID == "" {
+			u.ID = doc.Ref.ID
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+func (s *firestoreStore) GetSession(user string, id string) (*userSession, error) {
+	dsnap, err := s.client.Doc(fmt.Sprintf("users/%s/sessions/%s", user, id)).Get(s.ctx)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	var us userSession
+	if err := dsnap.DataTo(&us); err != nil {
+		return nil, err
+	}
+	return &us, nil
+}
+
+func (s *firestoreStore) SaveSession(user string, session *userSession) error {
+	_, err := s.client.Doc(fmt.Sprintf("users/%s/sessions/%s", user, session.ID)).Set(s.ctx, session)
+	return err
+}
+
+func (s *firestoreStore) Sessions(user string) ([]userSession, error) {
+	docs, err := s.client.Collection(fmt.Sprintf("users/%s/sessions", user)).Documents(s.ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	list := []userSession{}
+	for _, doc := range docs {
+		var us userSession
+		if err := doc.DataTo(&us); err != nil {
+			log.Printf("Sessions: skipping %s %s", doc.Ref.Path, err.Error())
+			continue
+		}
+		list = append(list, us)
+	}
+	return list, nil
+}
+
+func (s *firestoreStore) DeleteSession(user string, id string) error {
+	_, err := s.client.Doc(fmt.Sprintf("users/%s/sessions/%s", user, id)).Delete(s.ctx)
+	return err
+}
+
+func (s *firestoreStore) SaveRecentlyPlayed(user string, tracks []firestoreTrack) error {
+	return s.savePlays(user, tracks, false)
+}
+
+/*savePlays - writes plays in batches (Firestore allows 500 writes
+in a batch). Migrated plays are marked so CloudCounter skips them.
+*/
+func (s *firestoreStore) savePlays(user string, tracks []firestoreTrack, migrated bool) error {
+	path := fmt.Sprintf("users/%s/plays", user)
+	for start := 0; start < len(tracks); start += 500 {
+		end := start + 500
+		if end > len(tracks) {
+			end = len(tracks)
+		}
+		batch := s.client.Batch()
+		for _, tr := range tracks[start:end] {
+			play := map[string]interface{}{
+				"played_at":   tr.PlayedAt,
+				"track_name":  tr.Name,
+				"artists":     tr.Artists,
+				"id":          tr.ID,
+				"duration_ms": tr.Duration,
+				"skipped":     tr.Skipped,
+			}
+			if migrated {
+				play["migrated"] = true
+			}
+			// merge - saving a play again mustn't drop counted flag of CloudCounter
+			batch.Set(s.client.Collection(path).Doc(playID(tr)), play, firestore.MergeAll)
+		}
+		if _, err := batch.Commit(s.ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *firestoreStore) RecentlyPlayed(user string, q historyQuery) ([]firestoreTrack, error) {
+	path := fmt.Sprintf("users/%s/plays", user)
+	query := s.client.Collection(path).OrderBy("played_at", firestore.Desc)
+	if !q.Before.IsZero() {
+		query = query.StartAfter(q.Before)
+	} else if q.Offset > 0 {
+		query = query.Offset(q.Offset)
+	}
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	iter := query.Documents(s.ctx)
+	defer iter.Stop()
+	tracks := []firestoreTrack{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return tracks, err
+		}
+		var tr firestoreTrack
+		if err := doc.DataTo(&tr); err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		tracks = append(tracks, tr)
+	}
+	return tracks, nil
+}
+
+func (s *firestoreStore) MigratePlays(user string) (int, error) {
+	path := fmt.Sprintf("users/%s/recently_played", user)
+	docs, err := s.client.Collection(path).Documents(s.ctx).GetAll()
+	if err != nil {
+		return 0, err
+	}
+	tracks := []firestoreTrack{}
+	for _, doc := range docs {
+		var tr firestoreTrack
+		if err := doc.DataTo(&tr); err != nil {
+			log.Printf("MigratePlays: skipping %s %s", doc.Ref.Path, err.Error())
+			continue
+		}
+		if tr.ID == "" { // legacy documents are keyed by track ID
+			tr.ID = doc.Ref.ID
+		}
+		tracks = append(tracks, tr)
+	}
+	return len(tracks), s.savePlays(user, tracks, true)
+}
+
+func (s *firestoreStore) PopularTracks(user string, window string, limit int) ([]popularTrack, error) {
+	path := fmt.Sprintf("users/%s/popular_tracks", user)
+	field := "count"
+	if _, ok := popularWindows[window]; ok {
+		field = "count_" + window
+	}
+	iter := s.client.Collection(path).Where(field, ">", 0).OrderBy(field, firestore.Desc).Limit(limit).Documents(s.ctx)
+	defer iter.Stop()
+	tracks := []popularTrack{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return tracks, err
+		}
+		var pt popularTrack
+		if err := doc.DataTo(&pt); err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		pt.ID = doc.Ref.ID
+		tracks = append(tracks, pt)
+	}
+	return tracks, nil
+}
+
+/*PurgePlays - plays are rolled up and deleted in the same batch
+so no play is ever counted twice (or lost) if we fail halfway
+*/
+func (s *firestoreStore) PurgePlays(user string, before time.Time) (int, error) {
+	path := fmt.Sprintf("users/%s/plays", user)
+	query := s.client.Collection(path).Where("played_at", "<", before).OrderBy("played_at", firestore.Asc)
+	n := 0
+	for {
+		// leaves room in batch for rollups
+		docs, err := query.Limit(400).Documents(s.ctx).GetAll()
+		if err != nil {
+			return n, err
+		}
+		if len(docs) == 0 {
+			return n, nil
+		}
+		batch := s.client.Batch()
+		tracks := []firestoreTrack{}
+		for _, doc := range docs {
+			var tr firestoreTrack
+			if err := doc.DataTo(&tr); err != nil {
+				log.Printf("PurgePlays: %s %s", doc.Ref.Path, err.Error())
+			} else {
+				tracks = append(tracks, tr)
+			}
+			batch.Delete(doc.Ref)
+		}
+		for month, r := range rollupPlays(tracks) {
+			ref := s.client.Collection(fmt.Sprintf("users/%s/rollups", user)).Doc(month)
+			batch.Set(ref, rollupIncrements(r), firestore.MergeAll)
+		}
+		if _, err := batch.Commit(s.ctx); err != nil {
+			return n, err
+		}
+		n += len(docs)
+	}
+}
+
+/*rollupIncrements - rollup as Firestore increments (so it is added
+to what has been rolled up before)
+*/
+func rollupIncrements(r *monthlyRollup) map[string]interface{} {
+	tracks := map[string]interface{}{}
+	for id, count := range r.Tracks {
+		tracks[id] = firestore.Increment(count)
+	}
+	artists := map[string]interface{}{}
+	for artist, count := range r.Artists {
+		artists[artist] = firestore.Increment(count)
+	}
+	fields := map[string]interface{}{
+		"month":   r.Month,
+		"plays":   firestore.Increment(r.Plays),
+		"skips":   firestore.Increment(r.Skips),
+		"minutes": firestore.Increment(r.Minutes),
+	}
+	// empty map would replace what is there
+	if len(tracks) > 0 {
+		fields["tracks"] = tracks
+	}
+	if len(artists) > 0 {
+		fields["artists"] = artists
+	}
+	return fields
+}
+
+func (s *firestoreStore) Rollups(user string) ([]monthlyRollup, error) {
+	path := fmt.Sprintf("users/%s/rollups", user)
+	docs, err := s.client.Collection(path).OrderBy("month", firestore.Asc).Documents(s.ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	rollups := []monthlyRollup{}
+	for _, doc := range docs {
+		var r monthlyRollup
+		if err := doc.DataTo(&r); err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		rollups = append(rollups, r)
+	}
+	return rollups, nil
+}
+
+func (s *firestoreStore) EachPlay(user string, from time.Time, to time.Time, fn func(firestoreTrack) error) error {
+	path := fmt.Sprintf("users/%s/plays", user)
+	query := s.client.Collection(path).OrderBy("played_at", firestore.Asc)
+	if !from.IsZero() {
+		query = query.Where("played_at", ">=", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("played_at", "<", to)
+	}
+	iter := query.Documents(s.ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var tr firestoreTrack
+		if err := doc.DataTo(&tr); err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		if err := fn(tr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *firestoreStore) TrackFeatures(ids []string) (map[string]trackFeatures, error) {
+	features := map[string]trackFeatures{}
+	if len(ids) == 0 {
+		return features, nil
+	}
+	refs := []*firestore.DocumentRef{}
+	for _, id := range ids {
+		refs = append(refs, s.client.Collection("audio_features").Doc(id))
+	}
+	docs, err := s.client.GetAll(s.ctx, refs)
+	if err != nil {
+		return features, err
+	}
+	for _, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+		var f trackFeatures
+		if err := doc.DataTo(&f); err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		f.ID = doc.Ref.ID
+		features[f.ID] = f
+	}
+	return features, nil
+}
+
+func (s *firestoreStore) SaveTrackFeatures(features []trackFeatures) error {
+	for start := 0; start < len(features); start += 500 {
+		end := start + 500
+		if end > len(features) {
+			end = len(features)
+		}
+		batch := s.client.Batch()
+		for _, f := range features[start:end] {
+			batch.Set(s.client.Collection("audio_features").Doc(f.ID), f)
+		}
+		if _, err := batch.Commit(s.ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *firestoreStore) Close() error {
+	return s.client.Close()
+}
+
+/*notFound - translates Firestore NotFound into errNotFound
+ */
+func notFound(err error) error {
+	if status.Code(err) == codes.NotFound {
+		return errNotFound
+	}
+	return err
+}
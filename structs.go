@@ -8,15 +8,120 @@ import (
 )
 
 type firestoreTrack struct {
-	Name     string    `firestore:"track_name"`
-	Artists  string    `firestore:"artists"`
-	PlayedAt time.Time `firestore:"played_at"`
-	ID       string    `firestore:"id,omitempty"`
+	Name     string    `firestore:"track_name" json:"track_name"`
+	Artists  string    `firestore:"artists" json:"artists"`
+	PlayedAt time.Time `firestore:"played_at" json:"played_at"`
+	ID       string    `firestore:"id,omitempty" json:"id,omitempty"`
+	Duration int       `firestore:"duration_ms,omitempty" json:"duration_ms,omitempty"` // track length
+	Skipped  bool      `firestore:"skipped,omitempty" json:"skipped,omitempty"`         // next play started too early
 }
 
-// TODO - used only once
 type popularTrack struct {
-	Count int `firestore:"count,omitempty"`
+	ID    string `firestore:"-" json:"-"`                             // document ID == track ID
+	Count int    `firestore:"count,omitempty" json:"count,omitempty"` // real listens
+	Skips int    `firestore:"skips,omitempty" json:"skips,omitempty"` // skipped plays
+	// listens in popularity windows (see popularWindows)
+	Count7d  int `firestore:"count_7d,omitempty" json:"count_7d,omitempty"`
+	Count30d int `firestore:"count_30d,omitempty" json:"count_30d,omitempty"`
+}
+
+/*windowCount - listens in window ("7d", "30d"), all time otherwise
+ */
+func (pt popularTrack) windowCount(window string) int {
+	switch window {
+	case "7d":
+		return pt.Count7d
+	case "30d":
+		return pt.Count30d
+	}
+	return pt.Count
+}
+
+/*skipRate - share of plays which have been skipped
+ */
+func (pt popularTrack) skipRate() float64 {
+	if pt.Count+pt.Skips == 0 {
+		return 0
+	}
+	return float64(pt.Skips) / float64(pt.Count+pt.Skips)
+}
+
+// users/{userID} document
+type firestoreUser struct {
+	ID           string    `firestore:"userID" json:"userID"` // Spotify user ID
+	DisplayName  string    `firestore:"user_displayname,omitempty" json:"user_displayname,omitempty"`
+	Email        string    `firestore:"user_email,omitempty" json:"user_email,omitempty"`
+	Premium      bool      `firestore:"premium_user,omitempty" json:"premium_user,omitempty"`
+	Expiration   time.Time `firestore:"subscription_expires,omitempty" json:"subscription_expires,omitempty"`
+	Country      string    `firestore:"country,omitempty" json:"country,omitempty"`
+	TokenSaved   time.Time `firestore:"token_saved,omitempty" json:"token_saved,omitempty"`
+	TokenUpdated time.Time `firestore:"token_updated,omitempty" json:"token_updated,omitempty"`
+	// valid, revoked or scope-mismatch and when it has been set
+	TokenStatus        string    `firestore:"token_status,omitempty" json:"token_status,omitempty"`
+	TokenStatusUpdated time.Time `firestore:"token_status_updated,omitempty" json:"token_status_updated,omitempty"`
+	// played_at and track ID of the newest play we have ingested
+	IngestCursor time.Time `firestore:"ingest_cursor,omitempty" json:"ingest_cursor,omitempty"`
+	IngestLastID string    `firestore:"ingest_last_id,omitempty" json:"ingest_last_id,omitempty"`
+	// days plays are kept for, overrides plan default (see retention.go)
+	RetentionDays int `firestore:"retention_days,omitempty" json:"retention_days,omitempty"`
+	// sha256 of history files imported (see importer.go)
+	ImportedFiles []string `firestore:"imported_files,omitempty" json:"imported_files,omitempty"`
+	// ListenBrainz-compatible server plays are submitted to (see scrobble.go)
+	ScrobbleURL     string    `firestore:"scrobble_url,omitempty" json:"scrobble_url,omitempty"`
+	ScrobbleUser    string    `firestore:"scrobble_user,omitempty" json:"scrobble_user,omitempty"`
+	ScrobbleCursor  time.Time `firestore:"scrobble_cursor,omitempty" json:"scrobble_cursor,omitempty"` // played_at of last play sent
+	ScrobbleCount   int       `firestore:"scrobble_count,omitempty" json:"scrobble_count,omitempty"`
+	ScrobbleError   string    `firestore:"scrobble_error,omitempty" json:"scrobble_error,omitempty"`
+	ScrobbleUpdated time.Time `firestore:"scrobble_updated,omitempty" json:"scrobble_updated,omitempty"`
+	// how /mood recommends (see mood.go)
+	MoodOptions *moodOptions `firestore:"mood_options,omitempty" json:"mood_options,omitempty"`
+	// IANA timezone user's browser reported last (see stats.go)
+	Timezone string `firestore:"timezone,omitempty" json:"timezone,omitempty"`
+	// tokens of other services by name ("listenbrainz"), kept apart from Spotify tokens
+	Integrations map[string]integrationToken `firestore:"integrations,omitempty" json:"integrations,omitempty"`
+}
+
+// token of another service kept in user document, encrypted
+// like Spotify tokens (see tokencrypt.go). Empty Token - none.
+type integrationToken struct {
+	Token      string `firestore:"token" json:"token"`
+	KeyID      string `firestore:"key_id" json:"key_id,omitempty"`
+	WrappedKey []byte `firestore:"wrapped_key" json:"wrapped_key,omitempty"`
+}
+
+// users/{userID}/rollups/{YYYY-MM} document - plays of a month (UTC)
+// summed up before they are deleted
+type monthlyRollup struct {
+	Month   string         `firestore:"month" json:"month"`
+	Plays   int            `firestore:"plays" json:"plays"` // real listens
+	Skips   int            `firestore:"skips" json:"skips"`
+	Minutes float64        `firestore:"minutes" json:"minutes"` // listened (without skips)
+	Tracks  map[string]int `firestore:"tracks" json:"tracks"`   // listens by track ID
+	Artists map[string]int `firestore:"artists" json:"artists"` // listens by artist name
+}
+
+// audio_features/{trackID} document - Spotify audio features of a track
+// (kept whenever we fetch them, they don't change)
+type trackFeatures struct {
+	ID               string  `firestore:"-" json:"-"`
+	Acousticness     float64 `firestore:"acousticness" json:"acousticness"`
+	Danceability     float64 `firestore:"danceability" json:"danceability"`
+	Energy           float64 `firestore:"energy" json:"energy"`
+	Instrumentalness float64 `firestore:"instrumentalness" json:"instrumentalness"`
+	Liveness         float64 `firestore:"liveness" json:"liveness"`
+	Loudness         float64 `firestore:"loudness" json:"loudness"`
+	Speechiness      float64 `firestore:"speechiness" json:"speechiness"`
+	Tempo            float64 `firestore:"tempo" json:"tempo"`
+	Valence          float64 `firestore:"valence" json:"valence"`
+}
+
+// users/{userID}/sessions/{uuid} document - browser user is logged in with
+type userSession struct {
+	ID        string    `firestore:"id" json:"id"` // session uuid
+	UserAgent string    `firestore:"user_agent" json:"user_agent"`
+	Created   time.Time `firestore:"created" json:"created"`
+	LastSeen  time.Time `firestore:"last_seen" json:"last_seen"`
+	Current   bool      `firestore:"-" json:"-"` // the one user is looking from
 }
 
 // the name - this is what we need to
@@ -31,6 +136,20 @@ type firestoreToken struct {
 	country     string        `firestore:"country,omitempty"` // The country of the user, as set in the user's account profile
 	path        string        // authorization path (gin routes group)
 	token       *oauth2.Token // Spotify token
+	scopes      []string      // scopes granted with token (nil if unknown)
+}
+
+// users/{userID}/tokens/{path} document
+// field names are the same as oauth2.Token used to be stored directly
+type storedToken struct {
+	AccessToken  string    `firestore:"AccessToken" json:"access_token"`
+	TokenType    string    `firestore:"TokenType" json:"token_type,omitempty"`
+	RefreshToken string    `firestore:"RefreshToken" json:"refresh_token,omitempty"`
+	Expiry       time.Time `firestore:"Expiry" json:"expiry,omitempty"`
+	Scopes       []string  `firestore:"scopes,omitempty" json:"scopes,omitempty"` // granted scopes
+	// when set AccessToken and RefreshToken are encrypted (see tokencrypt.go)
+	KeyID      string `firestore:"key_id" json:"key_id,omitempty"`
+	WrappedKey []byte `firestore:"wrapped_key" json:"wrapped_key,omitempty"`
 }
 
 type navigation struct {
@@ -46,13 +165,17 @@ type navigation struct {
 type recommendationParameters struct {
 	Seeds           spotify.Seeds
 	TrackAttributes *spotify.TrackAttributes
-	FromYear        int
-	MinTrackCount   int
+	FromYear        int          // released in or after (0 - any)
+	ToYear          int          // released in or before (0 - any)
+	MinTrackCount   int          // tracks wanted (pageLimit if 0)
+	Known           *knownTracks // tracks left out if not nil (see known.go)
+	KnownArtists    bool         // also tracks of known artists
 }
 
 // TODO - its just tracks now, not topTracks
 type topTrack struct {
 	Count       int
+	SkipRate    int // percent
 	Name        string
 	Artists     string
 	URL         string
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+
+	spotify "github.com/chew-z/spotify"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	tasteClusters   = 4   // facets at most
+	tasteMinCluster = 5   // tracks a facet needs at least
+	tasteSeeds      = 5   // representative tracks seeding facet
+	tasteTracks     = 8   // recommended tracks of a facet
+	tasteHistory    = 100 // latest plays taken with top tracks
+	kmeansRounds    = 25
+	maxTempo        = 250.0 // scales tempo to 0-1 like other features
+	// facets closer than that (squared distance) are too alike to show apart
+	tasteDistinct = 0.04
+)
+
+// audio features tracks are clustered by, all scaled to 0-1
+var tasteDimensions = []string{"acousticness", "danceability", "energy", "instrumentalness", "valence", "tempo"}
+
+/*tasteFacet - cluster of user's tracks with similar audio features
+and recommendations seeded by it
+*/
+type tasteFacet struct {
+	Name       string
+	Size       int        // user's tracks in cluster
+	Seeds      []topTrack // representative tracks
+	Attributes []facetRange
+	Tracks     []topTrack // recommended
+}
+
+/*facetRange - attribute range (and center) of facet as shown on page
+ */
+type facetRange struct {
+	Name   string
+	Min    float64
+	Max    float64
+	Target float64
+}
+
+/*taste - user's top and recent tracks clustered by audio features
+into taste facets, recommendations are shown for every facet
+*/
+func taste(c *gin.Context) {
+	endpoint := c.Request.URL.Path
+	spotifyClient := clientMagic(c)
+	if spotifyClient == nil {
+		c.JSON(http.StatusTeapot, gin.H{endpoint: "failed to find Spotify client"})
+		return
+	}
+	session := sessions.Default(c)
+	user, _ := session.Get("user").(string)
+	country, _ := session.Get("country").(string)
+	facets, err := recommendFromTaste(spotifyClient, user, country)
+	if err != nil {
+		log.Printf("%s: %s %s", endpoint, user, err.Error())
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	c.HTML(
+		http.StatusOK,
+		"taste.html",
+		gin.H{
+			"Facets": facets,
+			"title":  "Taste",
+		},
+	)
+}
+
+/*recommendFromTaste - replaces recommending for every top artist with
+averaged attributes of all top tracks (country tracks for Miles Davis).
+Top and recent tracks are clustered (k-means) and every cluster is
+seeded by its own tracks and limited to its own attribute ranges.
+*/
+func recommendFromTaste(spotifyClient *spotify.Client, user string, country string) ([]tasteFacet, error) {
+	ids := []spotify.ID{}
+	limit := 50
+	top, err := spotifyClient.CurrentUsersTopTracksOpt(&spotify.Options{Limit: &limit})
+	if err != nil {
+		log.Printf("recommendFromTaste: top tracks of %s %s", user, err.Error())
+	} else {
+		for _, tr := range top.Tracks {
+			ids = appendIfUnique(ids, tr.ID)
+		}
+	}
+	recent, err := db.RecentlyPlayed(user, historyQuery{Limit: tasteHistory})
+	if err != nil {
+		log.Printf("recommendFromTaste: history of %s %s", user, err.Error())
+	}
+	for _, tr := range recent {
+		if tr.ID != "" {
+			ids = appendIfUnique(ids, spotify.ID(tr.ID))
+		}
+	}
+	if len(ids) < tasteMinCluster {
+		return nil, errors.New("Not enough tracks to tell your taste yet")
+	}
+	features, err := moodFeatures(spotifyClient, ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(features) < tasteMinCluster { // Spotify has no features for some tracks
+		return nil, errors.New("Not enough tracks to tell your taste yet")
+	}
+	points := [][]float64{}
+	for _, f := range features {
+		points = append(points, tasteVector(f))
+	}
+	k := len(points) / tasteMinCluster
+	if k > tasteClusters {
+		k = tasteClusters
+	}
+	// the same tracks give the same facets
+	h := fnv.New64a()
+	for _, f := range features {
+		h.Write([]byte(f.ID))
+	}
+	// fewer facets until they are distinct
+	var assignment []int
+	var centroids [][]float64
+	for ; k >= 1; k-- {
+		assignment, centroids = kmeans(points, k, rand.New(rand.NewSource(int64(h.Sum64()))))
+		if distinct(centroids) {
+			break
+		}
+	}
+	// tracks of clusters, closest to centroid first
+	members, centroids := mergeSmallClusters(points, assignment, centroids, tasteMinCluster)
+	facets := []tasteFacet{}
+	for cluster, centroid := range centroids {
+		m := members[cluster]
+		sort.Slice(m, func(i, j int) bool {
+			return distance(points[m[i]], centroid) < distance(points[m[j]], centroid)
+		})
+		seeds := []spotify.ID{}
+		for _, i := range m {
+			if len(seeds) == tasteSeeds {
+				break
+			}
+			seeds = append(seeds, spotify.ID(features[i].ID))
+		}
+		attributes, ranges := facetAttributes(points, m, centroid)
+		params := recommendationParameters{
+			MinTrackCount:   tasteTracks,
+			Seeds:           spotify.Seeds{Tracks: seeds},
+			TrackAttributes: attributes,
+		}
+		recommended, err := getRecommendedTracks(spotifyClient, params, &country)
+		if err != nil {
+			log.Printf("recommendFromTaste: facet %d of %s %s", cluster, user, err.Error())
+		}
+		seedTracks, err := fullTrackGetMany(spotifyClient, seeds)
+		if err != nil {
+			log.Printf("recommendFromTaste: %s", err.Error())
+		}
+		facets = append(facets, tasteFacet{
+			Name:       facetName(centroid),
+			Size:       len(m),
+			Seeds:      asTopTracks(seedTracks),
+			Attributes: ranges,
+			Tracks:     asTopTracks(recommended),
+		})
+	}
+	// biggest facet first
+	sort.SliceStable(facets, func(i, j int) bool {
+		return facets[i].Size > facets[j].Size
+	})
+	return facets, nil
+}
+
+/*tasteVector - features of tracks in tasteDimensions order
+ */
+func tasteVector(f trackFeatures) []float64 {
+	return []float64{f.Acousticness, f.Danceability, f.Energy, f.Instrumentalness, f.Valence, math.Min(f.Tempo/maxTempo, 1)}
+}
+
+func distance(a, b []float64) float64 {
+	var d float64
+	for i := range a {
+		d += (a[i] - b[i]) * (a[i] - b[i])
+	}
+	return d
+}
+
+/*distinct - true if no two centroids are closer than tasteDistinct
+ */
+func distinct(centroids [][]float64) bool {
+	for i := range centroids {
+		for j := i + 1; j < len(centroids); j++ {
+			if distance(centroids[i], centroids[j]) < tasteDistinct {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+/*kmeans - clusters points into k clusters (k-means++ start, Lloyd's
+iterations). Returns cluster of every point and cluster centroids.
+*/
+func kmeans(points [][]float64, k int, rng *rand.Rand) ([]int, [][]float64) {
+	if k < 1 {
+		k = 1
+	}
+	if k > len(points) {
+		k = len(points)
+	}
+	// k-means++ - next centroid is likely far from these chosen
+	centroids := [][]float64{append([]float64{}, points[rng.Intn(len(points))]...)}
+	for len(centroids) < k {
+		weights := make([]float64, len(points))
+		var total float64
+		for i, p := range points {
+			weights[i] = math.Inf(1)
+			for _, c := range centroids {
+				weights[i] = math.Min(weights[i], distance(p, c))
+			}
+			total += weights[i]
+		}
+		if total == 0 { // fewer distinct points than k
+			break
+		}
+		r := rng.Float64() * total
+		next := len(points) - 1
+		for i, w := range weights {
+			if r -= w; r <= 0 {
+				next = i
+				break
+			}
+		}
+		centroids = append(centroids, append([]float64{}, points[next]...))
+	}
+	assignment := make([]int, len(points))
+	for round := 0; round < kmeansRounds; round++ {
+		changed := round == 0
+		for i, p := range points {
+			best := 0
+			for c := range centroids {
+				if distance(p, centroids[c]) < distance(p, centroids[best]) {
+					best = c
+				}
+			}
+			if assignment[i] != best {
+				assignment[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		// centroids move to the mean of their points
+		sums := make([][]float64, len(centroids))
+		counts := make([]int, len(centroids))
+		for c := range sums {
+			sums[c] = make([]float64, len(points[0]))
+		}
+		for i, p := range points {
+			counts[assignment[i]]++
+			for d, v := range p {
+				sums[assignment[i]][d] += v
+			}
+		}
+		for c := range centroids {
+			if counts[c] == 0 { // empty cluster stays where it was
+				continue
+			}
+			for d := range sums[c] {
+				centroids[c][d] = sums[c][d] / float64(counts[c])
+			}
+		}
+	}
+	return assignment, centroids
+}
+
+/*mergeSmallClusters - tracks of clusters with at least min tracks and their
+centroids. Tracks of smaller clusters join the nearest of these (which moves
+to the mean of its tracks), if there is none all tracks make one cluster.
+*/
+func mergeSmallClusters(points [][]float64, assignment []int, centroids [][]float64, min int) ([][]int, [][]float64) {
+	members := make([][]int, len(centroids))
+	for i, cluster := range assignment {
+		members[cluster] = append(members[cluster], i)
+	}
+	kept := []int{}
+	for cluster, m := range members {
+		if len(m) >= min {
+			kept = append(kept, cluster)
+		}
+	}
+	if len(kept) == len(centroids) {
+		return members, centroids
+	}
+	merged := make([][]int, len(kept))
+	if len(kept) == 0 {
+		merged = [][]int{make([]int, len(points))}
+		for i := range points {
+			merged[0][i] = i
+		}
+		return merged, [][]float64{mean(points, merged[0])}
+	}
+	for i, p := range points {
+		best := 0
+		for k, cluster := range kept {
+			if cluster == assignment[i] {
+				best = k
+				break
+			}
+			if distance(p, centroids[cluster]) < distance(p, centroids[kept[best]]) {
+				best = k
+			}
+		}
+		merged[best] = append(merged[best], i)
+	}
+	mergedCentroids := make([][]float64, len(kept))
+	for k, cluster := range kept {
+		mergedCentroids[k] = centroids[cluster]
+		if len(merged[k]) > len(members[cluster]) {
+			mergedCentroids[k] = mean(points, merged[k])
+		}
+	}
+	return merged, mergedCentroids
+}
+
+/*mean - centroid of points with indexes
+ */
+func mean(points [][]float64, indexes []int) []float64 {
+	c := make([]float64, len(points[0]))
+	for _, i := range indexes {
+		for d, v := range points[i] {
+			c[d] += v
+		}
+	}
+	for d := range c {
+		c[d] /= float64(len(indexes))
+	}
+	return c
+}
+
+/*facetAttributes - attribute ranges of cluster (mean plus minus
+standard deviation of its tracks) with centroid as target
+*/
+func facetAttributes(points [][]float64, members []int, centroid []float64) (*spotify.TrackAttributes, []facetRange) {
+	attributes := spotify.NewTrackAttributes()
+	ranges := []facetRange{}
+	for d, name := range tasteDimensions {
+		var variance float64
+		for _, i := range members {
+			variance += (points[i][d] - centroid[d]) * (points[i][d] - centroid[d])
+		}
+		spread := math.Sqrt(variance / float64(len(members)))
+		spread = math.Max(spread, 0.05) // single track facet still gets some room
+		r := facetRange{
+			Name:   name,
+			Min:    math.Max(centroid[d]-spread, 0),
+			Max:    math.Min(centroid[d]+spread, 1),
+			Target: centroid[d],
+		}
+		scale := 1.0
+		if name == "tempo" {
+			scale = maxTempo
+		}
+		r.Min, r.Max, r.Target = r.Min*scale, r.Max*scale, r.Target*scale
+		switch name {
+		case "acousticness":
+			attributes.MinAcousticness(r.Min).MaxAcousticness(r.Max).TargetAcousticness(r.Target)
+		case "danceability":
+			attributes.MinDanceability(r.Min).MaxDanceability(r.Max).TargetDanceability(r.Target)
+		case "energy":
+			attributes.MinEnergy(r.Min).MaxEnergy(r.Max).TargetEnergy(r.Target)
+		case "instrumentalness":
+			attributes.MinInstrumentalness(r.Min).MaxInstrumentalness(r.Max).TargetInstrumentalness(r.Target)
+		case "valence":
+			attributes.MinValence(r.Min).MaxValence(r.Max).TargetValence(r.Target)
+		case "tempo":
+			attributes.MinTempo(r.Min).MaxTempo(r.Max).TargetTempo(r.Target)
+		}
+		ranges = append(ranges, r)
+	}
+	return attributes, ranges
+}
+
+/*facetName - words for what stands out in facet
+ */
+func facetName(centroid []float64) string {
+	words := []string{}
+	add := func(value float64, high float64, highWord string, low float64, lowWord string) {
+		if value >= high {
+			words = append(words, highWord)
+		} else if value <= low && lowWord != "" {
+			words = append(words, lowWord)
+		}
+	}
+	add(centroid[2], 0.7, "energetic", 0.35, "calm")
+	add(centroid[4], 0.65, "cheerful", 0.3, "melancholic")
+	add(centroid[1], 0.7, "danceable", 0, "")
+	add(centroid[0], 0.6, "acoustic", 0, "")
+	add(centroid[3], 0.5, "instrumental", 0, "")
+	if len(words) == 0 {
+		words = append(words, "balanced")
+	}
+	name := strings.Join(words, ", ")
+	return strings.ToUpper(name[:1]) + name[1:] + fmt.Sprintf(" (%.0f BPM)", centroid[5]*maxTempo)
+}
+
+func asTopTracks(tracks []spotify.FullTrack) []topTrack {
+	result := []topTrack{}
+	for _, item := range tracks {
+		tt := topTrack{
+			Name:    item.Name,
+			Album:   item.Album.Name,
+			Artists: joinArtists(item.Artists, ", "),
+			URL:     item.ExternalURLs["spotify"],
+		}
+		if len(item.Album.Images) > 0 {
+			tt.Image = item.Album.Images[0].URL
+		}
+		result = append(result, tt)
+	}
+	return result
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+/*tastePoints - n points around the same value in every dimension
+ */
+func tastePoints(n int, value float64) [][]float64 {
+	points := [][]float64{}
+	for i := 0; i < n; i++ {
+		p := make([]float64, len(tasteDimensions))
+		for d := range p {
+			p[d] = value + float64(i%3)*0.01
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+func TestKmeans(t *testing.T) {
+	tests := []struct {
+		name      string
+		points    [][]float64
+		k         int
+		centroids int
+		groups    [][]int // points which must share a cluster, groups apart
+	}{
+		{
+			name:      "separated groups",
+			points:    append(tastePoints(6, 0.1), tastePoints(6, 0.9)...),
+			k:         2,
+			centroids: 2,
+			groups:    [][]int{{0, 1, 2, 3, 4, 5}, {6, 7, 8, 9, 10, 11}},
+		},
+		{
+			name:      "k below one",
+			points:    tastePoints(4, 0.5),
+			k:         0,
+			centroids: 1,
+			groups:    [][]int{{0, 1, 2, 3}},
+		},
+		{
+			name:      "k above points",
+			points:    append(tastePoints(1, 0.1), tastePoints(1, 0.9)...),
+			k:         5,
+			centroids: 2,
+			groups:    [][]int{{0}, {1}},
+		},
+		{
+			name:      "fewer distinct points than k",
+			points:    [][]float64{{0.5, 0.5, 0.5, 0.5, 0.5, 0.5}, {0.5, 0.5, 0.5, 0.5, 0.5, 0.5}, {0.5, 0.5, 0.5, 0.5, 0.5, 0.5}},
+			k:         3,
+			centroids: 1,
+			groups:    [][]int{{0, 1, 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assignment, centroids := kmeans(tt.points, tt.k, rand.New(rand.NewSource(1)))
+			if len(centroids) != tt.centroids {
+				t.Fatalf("kmeans() %d centroids, want %d", len(centroids), tt.centroids)
+			}
+			seen := map[int]bool{}
+			for _, group := range tt.groups {
+				cluster := assignment[group[0]]
+				if seen[cluster] {
+					t.Errorf("kmeans() groups share cluster %d: %v", cluster, assignment)
+				}
+				seen[cluster] = true
+				for _, i := range group {
+					if assignment[i] != cluster {
+						t.Errorf("kmeans() split group %v: %v", group, assignment)
+					}
+				}
+			}
+			again, _ := kmeans(tt.points, tt.k, rand.New(rand.NewSource(1)))
+			if !reflect.DeepEqual(assignment, again) {
+				t.Errorf("kmeans() with the same seed = %v, then %v", assignment, again)
+			}
+		})
+	}
+}
+
+func TestMergeSmallClusters(t *testing.T) {
+	points := append(append(tastePoints(5, 0.1), tastePoints(5, 0.9)...), tastePoints(2, 0.8)...)
+	centroids := [][]float64{mean(points, []int{0, 1, 2, 3, 4}), mean(points, []int{5, 6, 7, 8, 9}), mean(points, []int{10, 11})}
+	tests := []struct {
+		name       string
+		assignment []int
+		centroids  [][]float64
+		min        int
+		want       [][]int
+	}{
+		{
+			name:       "all big enough",
+			assignment: []int{0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 2, 2},
+			centroids:  centroids,
+			min:        2,
+			want:       [][]int{{0, 1, 2, 3, 4}, {5, 6, 7, 8, 9}, {10, 11}},
+		},
+		{
+			name:       "small joins nearest",
+			assignment: []int{0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 2, 2},
+			centroids:  centroids,
+			min:        5,
+			want:       [][]int{{0, 1, 2, 3, 4}, {5, 6, 7, 8, 9, 10, 11}},
+		},
+		{
+			name:       "empty dropped",
+			assignment: []int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 2},
+			centroids:  [][]float64{mean(points, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}), centroids[1], centroids[2]},
+			min:        2,
+			want:       [][]int{{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, {10, 11}},
+		},
+		{
+			name:       "none big enough",
+			assignment: []int{0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 2, 2},
+			centroids:  centroids,
+			min:        20,
+			want:       [][]int{{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			members, merged := mergeSmallClusters(points, tt.assignment, tt.centroids, tt.min)
+			if !reflect.DeepEqual(members, tt.want) {
+				t.Fatalf("mergeSmallClusters() = %v, want %v", members, tt.want)
+			}
+			if len(merged) != len(members) {
+				t.Fatalf("mergeSmallClusters() %d centroids for %d clusters", len(merged), len(members))
+			}
+			for c, m := range members {
+				if want := mean(points, m); !reflect.DeepEqual(merged[c], want) {
+					t.Errorf("mergeSmallClusters() centroid %d = %v, want %v", c, merged[c], want)
+				}
+			}
+		})
+	}
+}
+
+func TestFacetAttributes(t *testing.T) {
+	approx := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	tests := []struct {
+		name     string
+		points   [][]float64
+		centroid []float64
+		min, max []float64
+	}{
+		{
+			name:     "single track gets minimum spread",
+			points:   [][]float64{{0.5, 0.5, 0.5, 0.5, 0.5, 0.5}},
+			centroid: []float64{0.5, 0.5, 0.5, 0.5, 0.5, 0.5},
+			min:      []float64{0.45, 0.45, 0.45, 0.45, 0.45, 0.45 * maxTempo},
+			max:      []float64{0.55, 0.55, 0.55, 0.55, 0.55, 0.55 * maxTempo},
+		},
+		{
+			name:     "standard deviation",
+			points:   [][]float64{{0.2, 0.2, 0.2, 0.2, 0.2, 0.2}, {0.6, 0.6, 0.6, 0.6, 0.6, 0.6}},
+			centroid: []float64{0.4, 0.4, 0.4, 0.4, 0.4, 0.4},
+			min:      []float64{0.2, 0.2, 0.2, 0.2, 0.2, 0.2 * maxTempo},
+			max:      []float64{0.6, 0.6, 0.6, 0.6, 0.6, 0.6 * maxTempo},
+		},
+		{
+			name:     "clamped to 0-1",
+			points:   [][]float64{{0, 1, 0, 1, 0, 1}},
+			centroid: []float64{0, 1, 0, 1, 0, 1},
+			min:      []float64{0, 0.95, 0, 0.95, 0, 0.95 * maxTempo},
+			max:      []float64{0.05, 1, 0.05, 1, 0.05, maxTempo},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			members := []int{}
+			for i := range tt.points {
+				members = append(members, i)
+			}
+			_, ranges := facetAttributes(tt.points, members, tt.centroid)
+			if len(ranges) != len(tasteDimensions) {
+				t.Fatalf("facetAttributes() %d ranges, want %d", len(ranges), len(tasteDimensions))
+			}
+			for d, r := range ranges {
+				if r.Name != tasteDimensions[d] || !approx(r.Min, tt.min[d]) || !approx(r.Max, tt.max[d]) {
+					t.Errorf("facetAttributes() %s = %v-%v, want %v-%v", r.Name, r.Min, r.Max, tt.min[d], tt.max[d])
+				}
+			}
+		})
+	}
+}
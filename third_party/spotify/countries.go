@@ -0,0 +1,32 @@
+package spotify
+
+// ISO 3166-1 alpha 2 country codes.
+//
+// see: https://en.wikipedia.org/wiki/ISO_3166-1_alpha-2
+const (
+	CountryArgentina          = "AR"
+	CountryAustralia          = "AU"
+	CountryAustria            = "AT"
+	CountryBelarus            = "BY"
+	CountryBelgium            = "BE"
+	CountryBrazil             = "BR"
+	CountryCanada             = "CA"
+	CountryChile              = "CL"
+	CountryChina              = "CN"
+	CountryGermany            = "DE"
+	CountryHongKong           = "HK"
+	CountryIreland            = "IE"
+	CountryIndia              = "IN"
+	CountryItaly              = "IT"
+	CountryJapan              = "JP"
+	CountrySpain              = "ES"
+	CountryFinland            = "FI"
+	CountryFrance             = "FR"
+	CountryMexico             = "MX"
+	CountryNewZealand         = "NZ"
+	CountryRussia             = "RU"
+	CountrySwitzerland        = "CH"
+	CountryUnitedArabEmirates = "AE"
+	CountryUnitedKingdom      = "GB"
+	CountryUSA                = "US"
+)
@@ -0,0 +1,8 @@
+module github.com/chew-z/spotify
+
+go 1.19
+
+require (
+	github.com/patrickmn/go-cache v2.1.0+incompatible
+	golang.org/x/oauth2 v0.3.0
+)
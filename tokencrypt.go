@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go-spotify/common/tokenkeys"
+)
+
+// keys tokens are encrypted with (nil - plaintext), set by initStore
+var tokenCipher *tokenkeys.Keyring
+
+/*sealToken - token with AccessToken and RefreshToken encrypted for user
+and path it is kept at (see tokenkeys.Keyring.Seal, which we share with
+CloudRecent)
+*/
+func sealToken(k *tokenkeys.Keyring, user string, path string, t *storedToken) (*storedToken, error) {
+	r, err := k.Seal(tokenkeys.Record{User: user, Path: path, AccessToken: t.AccessToken, RefreshToken: t.RefreshToken})
+	if err != nil {
+		return nil, err
+	}
+	sealed := *t
+	sealed.AccessToken, sealed.RefreshToken = r.AccessToken, r.RefreshToken
+	sealed.KeyID, sealed.WrappedKey = r.KeyID, r.WrappedKey
+	return &sealed, nil
+}
+
+/*openToken - token kept at user and path decrypted with any of known keys
+ */
+func openToken(k *tokenkeys.Keyring, user string, path string, t *storedToken) (*storedToken, error) {
+	r, err := k.Open(tokenkeys.Record{
+		User:         user,
+		Path:         path,
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		KeyID:        t.KeyID,
+		WrappedKey:   t.WrappedKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	opened := *t
+	opened.AccessToken, opened.RefreshToken = r.AccessToken, r.RefreshToken
+	return &opened, nil
+}
+
+/*sealIntegration - user's token of another service (name) encrypted like
+Spotify tokens
+*/
+func sealIntegration(k *tokenkeys.Keyring, user string, name string, token string) (integrationToken, error) {
+	r, err := k.Seal(tokenkeys.Record{User: user, Path: "integrations/" + name, AccessToken: token})
+	if err != nil {
+		return integrationToken{}, err
+	}
+	return integrationToken{Token: r.AccessToken, KeyID: r.KeyID, WrappedKey: r.WrappedKey}, nil
+}
+
+/*openIntegration - user's token of another service (name) decrypted with
+any of known keys
+*/
+func openIntegration(k *tokenkeys.Keyring, user string, name string, t integrationToken) (string, error) {
+	r, err := k.Open(tokenkeys.Record{User: user, Path: "integrations/" + name, AccessToken: t.Token, KeyID: t.KeyID, WrappedKey: t.WrappedKey})
+	if err != nil {
+		return "", err
+	}
+	return r.AccessToken, nil
+}
+
+/*tokenPath - where token of auth path is kept under user (users/{user}/tokens{path}
+in Firestore, same in CloudRecent)
+*/
+func tokenPath(path string) string {
+	return "tokens" + path
+}
+
+/*encryptedStore - Store which encrypts tokens on the way in and
+decrypts them on the way out, everything else goes straight through
+*/
+type encryptedStore struct {
+	Store
+	keys *tokenkeys.Keyring
+}
+
+func (s *encryptedStore) WithContext(ctx context.Context) Store {
+	return &encryptedStore{Store: s.Store.WithContext(ctx), keys: s.keys}
+}
+
+func (s *encryptedStore) GetToken(user string, path string) (*storedToken, error) {
+	tok, err := s.Store.GetToken(user, path)
+	if err != nil {
+		return nil, err
+	}
+	return openToken(s.keys, user, tokenPath(path), tok)
+}
+
+func (s *encryptedStore) SaveToken(user string, path string, token *storedToken) error {
+	sealed, err := sealToken(s.keys, user, tokenPath(path), token)
+	if err != nil {
+		return err
+	}
+	return s.Store.SaveToken(user, path, sealed)
+}
+
+func (s *encryptedStore) Tokens(user string) (map[string]*storedToken, error) {
+	tokens, err := s.Store.Tokens(user)
+	if err != nil {
+		return nil, err
+	}
+	for path, tok := range tokens {
+		opened, err := openToken(s.keys, user, tokenPath(path), tok)
+		if err != nil {
+			return nil, fmt.Errorf("%s%s: %w", user, path, err)
+		}
+		tokens[path] = opened
+	}
+	return tokens, nil
+}
+
+/*rotateTokenKeys - re-encrypts every stored token with active key
+(and encrypts those still kept in plaintext). Run as
+go-spotify rotate-keys after adding new key to TOKEN_KEYS
+and pointing TOKEN_KEY_ID at it.
+*/
+func rotateTokenKeys(s *encryptedStore) error {
+	users, err := s.Users()
+	if err != nil {
+		return err
+	}
+	var rotated, skipped, failed int
+	for _, u := range users {
+		raw, err := s.Store.Tokens(u.ID)
+		if err != nil {
+			log.Printf("rotate-keys: Error retrieving tokens of %s %s", u.ID, err.Error())
+			failed++
+			continue
+		}
+		for path, tok := range raw {
+			if s.keys != nil && tok.KeyID == s.keys.Active() {
+				skipped++
+				continue
+			}
+			opened, err := openToken(s.keys, u.ID, tokenPath(path), tok)
+			if err == nil {
+				err = s.SaveToken(u.ID, path, opened)
+			}
+			if err != nil {
+				log.Printf("rotate-keys: %s%s %s", u.ID, path, err.Error())
+				failed++
+				continue
+			}
+			rotated++
+		}
+		// integrations are saved whole (bbolt doesn't merge nested fields)
+		integrations := map[string]integrationToken{}
+		changed := 0
+		for name, t := range u.Integrations {
+			integrations[name] = t
+			if t.Token == "" || (s.keys != nil && t.KeyID == s.keys.Active()) {
+				continue
+			}
+			token, err := openIntegration(s.keys, u.ID, name, t)
+			if err == nil {
+				integrations[name], err = sealIntegration(s.keys, u.ID, name, token)
+			}
+			if err != nil {
+				log.Printf("rotate-keys: %s/%s %s", u.ID, name, err.Error())
+				integrations[name] = t
+				failed++
+				continue
+			}
+			changed++
+		}
+		if changed > 0 {
+			if err := s.UpdateUser(u.ID, map[string]interface{}{"integrations": integrations}); err != nil {
+				log.Printf("rotate-keys: Error saving integrations of %s %s", u.ID, err.Error())
+				failed += changed
+				continue
+			}
+			rotated += changed
+		}
+	}
+	log.Printf("rotate-keys: %d tokens re-encrypted, %d already current, %d failed", rotated, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("rotate-keys: %d tokens failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	spotify "github.com/chew-z/spotify"
+	"golang.org/x/oauth2"
+)
+
+// 401 right after refresh means authorization is gone (see classifyStatus)
+const refreshedRecently = time.Minute
+
+// tokenLocks - one mutex per user and auth path so concurrent
+// requests (sessions) of the same user don't race on writing token
+var tokenLocks sync.Map
+
+/*persistentTokenSource - wraps oauth2 token source of Spotify client
+and saves token into database every time it changes (after refresh)
+*/
+type persistentTokenSource struct {
+	src       oauth2.TokenSource
+	user      string
+	path      string
+	mu        sync.Mutex
+	last      *oauth2.Token
+	refreshed time.Time // when token has been refreshed last
+}
+
+func (s *persistentTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	src := s.src
+	s.mu.Unlock()
+	tok, err := src.Token()
+	if err != nil {
+		if status := classifyTokenError(err); status != "" {
+			quarantineToken(s.user, status)
+		}
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last != nil && tok.AccessToken == s.last.AccessToken && tok.RefreshToken == s.last.RefreshToken {
+		return tok, nil
+	}
+	s.last = tok
+	s.refreshed = time.Now()
+	persistToken(s.user, s.path, tok)
+	return tok, nil
+}
+
+/*justRefreshed - true if token has been refreshed a moment ago
+ */
+func (s *persistentTokenSource) justRefreshed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Since(s.refreshed) < refreshedRecently
+}
+
+/*expire - next request refreshes token whatever its expiry says
+ */
+func (s *persistentTokenSource) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src = oauthConfig.TokenSource(oauthContext, &oauth2.Token{RefreshToken: s.last.RefreshToken})
+}
+
+/*grantedScopes - scopes stored with token (nil if unknown)
+ */
+func (s *persistentTokenSource) grantedScopes() []string {
+	stored, err := db.GetToken(s.user, s.path)
+	if err != nil {
+		log.Printf("grantedScopes: %s%s %s", s.user, s.path, err.Error())
+		return nil
+	}
+	return stored.Scopes
+}
+
+/*persistToken - saves refreshed token unless newer one is
+already stored (by another session of the same user)
+*/
+func persistToken(user string, path string, tok *oauth2.Token) {
+	l, _ := tokenLocks.LoadOrStore(user+path, &sync.Mutex{})
+	lock := l.(*sync.Mutex)
+	lock.Lock()
+	defer lock.Unlock()
+	stored, err := db.GetToken(user, path)
+	if err == errNotFound { // user has signed out and asked us to forget token
+		log.Printf("persistToken: No token stored for %s%s", user, path)
+		return
+	}
+	if err == nil {
+		if stored.AccessToken == tok.AccessToken && stored.RefreshToken == tok.RefreshToken {
+			return
+		}
+		if stored.Expiry.After(tok.Expiry) {
+			log.Printf("persistToken: Newer token already stored for %s%s", user, path)
+			return
+		}
+	}
+	var newTok firestoreToken
+	newTok.user = user
+	newTok.path = path
+	newTok.token = tok
+	updateTokenInDB(&newTok)
+}
+
+/*newClient - creates Spotify client which persists its token
+whenever it gets refreshed and quarantines the token when Spotify
+says it has been revoked. Use it instead of auth.NewClient
+for every user whose token is kept in database.
+*/
+func newClient(tok *oauth2.Token, user string, path string) *spotify.Client {
+	src := &persistentTokenSource{
+		src:  oauthConfig.TokenSource(oauthContext, tok),
+		user: user,
+		path: path,
+		last: tok,
+	}
+	spotifyClient := spotify.NewClient(&http.Client{
+		Transport: &oauth2.Transport{
+			Source: src,
+			Base:   &statusTransport{base: spotifyTransport, src: src},
+		},
+	})
+	return &spotifyClient
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// token status kept in users/{userID} document
+const (
+	tokenValid         = "valid"
+	tokenRevoked       = "revoked"        // user de-authorized our app in Spotify
+	tokenScopeMismatch = "scope-mismatch" // token lacks scopes we need now
+)
+
+/*tokenQuarantined - true if token with such status is of no use
+ */
+func tokenQuarantined(status string) bool {
+	return status == tokenRevoked || status == tokenScopeMismatch
+}
+
+/*classifyTokenError - tells if refreshing token has failed because
+our authorization is dead (refresh token is no longer accepted).
+Returns token status or "" for errors which have nothing to do
+with user's authorization (network, rate limiting, our credentials etc.)
+*/
+func classifyTokenError(err error) string {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) && bytes.Contains(retrieveErr.Body, []byte("invalid_grant")) {
+		return tokenRevoked
+	}
+	return ""
+}
+
+/*classifyStatus - same as classifyTokenError for API response.
+Access token may expire on its way (or clocks differ) so 401 means
+revoked authorization only if token has just been refreshed.
+403 about scope counts only if granted scopes lack some we need.
+*/
+func classifyStatus(code int, message string, refreshed bool, granted []string) string {
+	switch {
+	case code == http.StatusUnauthorized && refreshed:
+		return tokenRevoked
+	case code == http.StatusForbidden && strings.Contains(strings.ToLower(message), "scope") && len(missingScopes(granted)) > 0:
+		return tokenScopeMismatch
+	}
+	return ""
+}
+
+/*quarantineToken - marks user's token as dead so background jobs skip
+the user and web flow asks user to authorize the app again
+*/
+func quarantineToken(user string, status string) {
+	var s string
+	if shared.Get(user, "quarantine", &s) && s == status {
+		return // already done
+	}
+	shared.Set(user, "quarantine", status, 24*time.Hour)
+	log.Printf("quarantineToken: Token of %s is %s", user, status)
+	if err := db.UpdateUser(user, map[string]interface{}{
+		"token_status":         status,
+		"token_status_updated": time.Now(),
+	}); err != nil {
+		log.Printf("quarantineToken: Error saving token status for %s %s", user, err.Error())
+	}
+}
+
+/*releaseToken - user authorized the app again
+ */
+func releaseToken(user string) {
+	shared.Delete(user, "quarantine")
+}
+
+/*quarantinedStatus - token status if user's token is quarantined
+(checked in cache first and if deep is set also in database)
+*/
+func quarantinedStatus(user string, deep bool) string {
+	var s string
+	if shared.Get(user, "quarantine", &s) {
+		return s
+	}
+	if deep {
+		if u, err := db.GetUser(user); err == nil && tokenQuarantined(u.TokenStatus) {
+			shared.Set(user, "quarantine", u.TokenStatus, 24*time.Hour)
+			return u.TokenStatus
+		}
+	}
+	return ""
+}
+
+/*reauthorize - clears session and cached client and explains user
+why we need Spotify authorization again (instead of panic)
+*/
+func reauthorize(c *gin.Context, status string) {
+	session := sessions.Default(c)
+	if uuid, ok := session.Get("uuid").(string); ok {
+		kaszka.Delete(uuid)
+	}
+	session.Clear()
+	session.Save()
+	log.Printf("reauthorize: %s", status)
+	c.HTML(http.StatusUnauthorized, "reauthorize.html", gin.H{
+		"title":  "Authorize again",
+		"status": status,
+		"url":    "/authorize?dialog=1",
+	})
+	c.Abort()
+}
+
+/*statusTransport - watches Spotify API responses of user's client
+for signs of revoked authorization or insufficient scope. 401 with
+a token which hasn't just been refreshed makes the client refresh it.
+*/
+type statusTransport struct {
+	base http.RoundTripper
+	src  *persistentTokenSource
+}
+
+func (t *statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body)) // let spotify client decode error
+		var granted []string
+		if resp.StatusCode == http.StatusForbidden {
+			granted = t.src.grantedScopes()
+		}
+		status := classifyStatus(resp.StatusCode, string(body), t.src.justRefreshed(), granted)
+		switch {
+		case status != "":
+			quarantineToken(t.src.user, status)
+		case resp.StatusCode == http.StatusUnauthorized:
+			log.Printf("statusTransport: Access token of %s%s not accepted, refreshing", t.src.user, t.src.path)
+			t.src.expire()
+		}
+	}
+	return resp, nil
+}
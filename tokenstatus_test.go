@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestClassifyStatus(t *testing.T) {
+	scope := `{"error":{"status":403,"message":"Insufficient client scope"}}`
+	tests := []struct {
+		name      string
+		code      int
+		message   string
+		refreshed bool
+		granted   []string
+		want      string
+	}{
+		{"expired on its way", http.StatusUnauthorized, "The access token expired", false, requiredScopes, ""},
+		{"401 right after refresh", http.StatusUnauthorized, "Invalid access token", true, requiredScopes, tokenRevoked},
+		{"scope missing", http.StatusForbidden, scope, false, requiredScopes[:1], tokenScopeMismatch},
+		{"scope granted", http.StatusForbidden, scope, false, requiredScopes, ""},
+		{"scopes unknown", http.StatusForbidden, scope, false, nil, ""},
+		{"forbidden for other reason", http.StatusForbidden, "Player command failed: Premium required", false, requiredScopes[:1], ""},
+		{"server error", http.StatusInternalServerError, "", true, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyStatus(tt.code, tt.message, tt.refreshed, tt.granted); got != tt.want {
+				t.Errorf("classifyStatus() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyTokenError(t *testing.T) {
+	retrieveErr := func(code int, body string) error {
+		return &oauth2.RetrieveError{Response: &http.Response{StatusCode: code}, Body: []byte(body)}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"refresh token revoked", retrieveErr(http.StatusBadRequest, `{"error":"invalid_grant"}`), tokenRevoked},
+		{"wrapped", fmt.Errorf("refreshing: %w", retrieveErr(http.StatusBadRequest, `{"error":"invalid_grant"}`)), tokenRevoked},
+		{"our credentials", retrieveErr(http.StatusUnauthorized, `{"error":"invalid_client"}`), ""},
+		{"token endpoint down", retrieveErr(http.StatusServiceUnavailable, ""), ""},
+		{"network", errors.New("connection reset by peer"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyTokenError(tt.err); got != tt.want {
+				t.Errorf("classifyTokenError() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
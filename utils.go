@@ -1,52 +1,110 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math"
 	"net/http"
-	"os"
 	"reflect"
 	"strconv"
 	"strings"
 	"time"
 
-	"cloud.google.com/go/firestore"
 	spotify "github.com/chew-z/spotify"
 	"github.com/gin-gonic/gin"
+	"github.com/patrickmn/go-cache"
 )
 
-/*getRecommendedTracks - gets recommendation based on seed
- */
+const recommendationAttempts = 5 // calls made to fill up recommendations
+
+// full albums by ID (see fullAlbumGet)
+var albumCache = cache.New(24*time.Hour, time.Hour)
+
+/*getRecommendedTracks - gets recommendation based on seed. Tracks
+released outside of FromYear - ToYear (or known to user if params.Known
+is set) are dropped and Spotify is asked
+again (at most recommendationAttempts times) until there is MinTrackCount
+of tracks (pageLimit if not set).
+*/
 func getRecommendedTracks(spotifyClient *spotify.Client, params recommendationParameters, country *string) ([]spotify.FullTrack, error) {
-	limit := pageLimit
+	want := params.MinTrackCount
+	if want <= 0 {
+		want = pageLimit
+	}
+	filtered := params.FromYear > 0 || params.ToYear > 0 || params.Known != nil
 	tracks := []spotify.FullTrack{}
-	options := spotify.Options{
-		Limit:   &limit,
-		Country: country, // TODO - this and location
+	seen := map[spotify.ID]bool{}
+	for attempt := 1; attempt <= recommendationAttempts && len(tracks) < want; attempt++ {
+		// ask for more than missing if some are going to be dropped
+		limit := want - len(tracks)
+		if filtered {
+			limit *= 2
+		}
+		if limit > maxRecommendations {
+			limit = maxRecommendations
+		}
+		options := spotify.Options{
+			Limit:   &limit,
+			Country: country, // TODO - this and location
+		}
+		page, err := spotifyClient.GetRecommendations(params.Seeds, params.TrackAttributes, &options)
+		if err != nil {
+			if len(tracks) > 0 { // what we have is better than nothing
+				log.Printf("getRecommendedTracks: attempt %d %s", attempt, err.Error())
+				break
+			}
+			return tracks, fmt.Errorf("Failed to get recommendations: %v", err)
+		}
+		ids := []spotify.ID{}
+		for _, id := range getSpotifyIDs(page.Tracks) {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 { // Spotify has nothing new for us
+			break
+		}
+		fullTracks, err := fullTrackGetMany(spotifyClient, ids)
+		if err != nil {
+			return tracks, err
+		}
+		for _, track := range fullTracks {
+			if params.Known.excludes(track.ID, track.Artists, params.KnownArtists) {
+				continue
+			}
+			if params.FromYear > 0 || params.ToYear > 0 {
+				year, err := releaseYear(spotifyClient, track)
+				if err != nil {
+					log.Println(err.Error())
+					continue
+				}
+				if (params.FromYear > 0 && year < params.FromYear) || (params.ToYear > 0 && year > params.ToYear) {
+					continue
+				}
+			}
+			tracks = append(tracks, track)
+		}
 	}
-	// get recommendtions (only single page)
-	page, err := spotifyClient.GetRecommendations(params.Seeds, params.TrackAttributes, &options)
-	if err != nil {
-		return tracks, fmt.Errorf("Failed to get recommendations: %v", err)
+	if len(tracks) > want {
+		tracks = tracks[:want]
 	}
-	// TODO - we might skip both logic before to speed up
-	// all this is only necessary to limit release date
-	fullTracks, err := fullTrackGetMany(spotifyClient, getSpotifyIDs(page.Tracks))
+	return tracks, nil
+}
+
+/*releaseYear - year track's album has been released, from track itself
+or from (cached) full album if track doesn't say
+*/
+func releaseYear(spotifyClient *spotify.Client, track spotify.FullTrack) (int, error) {
+	if track.Album.ReleaseDate != "" {
+		return track.Album.ReleaseDateTime().Year(), nil
+	}
+	album, err := fullAlbumGet(spotifyClient, track.Album.ID)
 	if err != nil {
-		return tracks, err
+		return 0, err
 	}
-	// for _, track := range fullTracks {
-	// 	album, err := fullAlbumGet(client, track.Album.ID)
-	// 	if err != nil {
-	// 		return tracks, err
-	// 	}
-	// 	if album.ReleaseDateTime().Year() >= params.FromYear {
-	// 		tracks = append(tracks, track)
-	// 	}
-	// }
-	// return tracks, nil
-	return fullTracks, nil
+	return album.ReleaseDateTime().Year(), nil
 }
 
 /*fullTracksGetMany - gets FullTrack objects for given track IDs
@@ -73,16 +131,18 @@ func fullTrackGetMany(spotifyClient *spotify.Client, ids []spotify.ID) ([]spotif
 	return tracks, nil
 }
 
+/*fullAlbumGet - full album, albums don't change so they are cached
+(per instance) for a while
+*/
 func fullAlbumGet(spotifyClient *spotify.Client, id spotify.ID) (spotify.FullAlbum, error) {
-	// var albumCache = map[spotify.ID]spotify.FullAlbum{}
-	// if album, exists := albumCache[id]; exists {
-	// 	return album, nil
-	// }
+	if album, exists := albumCache.Get(string(id)); exists {
+		return album.(spotify.FullAlbum), nil
+	}
 	album, err := spotifyClient.GetAlbum(id)
 	if err != nil {
 		return spotify.FullAlbum{}, fmt.Errorf("Failed to get full album %s: %v", id, err)
 	}
-	// albumCache[id] = *album
+	albumCache.Set(string(id), *album, cache.DefaultExpiration)
 	return *album, nil
 }
 
@@ -194,29 +254,6 @@ func appendIfUnique(slice []spotify.ID, i spotify.ID) []spotify.ID {
 	return append(slice, i)
 }
 
-/* normalizeRecentlyPlayed - my Spotify history has hiccups
-due to poor connection and switching between different players (Chromecast audio)
-so if two tracks are identical and started within 30 seconds take only one
-Spotify should do that (count at least 30 secs as played track) and theoretically does
- but its not
-*/
-func normalizeRecentlyPlayed(incoming []spotify.RecentlyPlayedItem) []spotify.RecentlyPlayedItem {
-	var outgoing []spotify.RecentlyPlayedItem
-	outgoing = append(outgoing, incoming[0])
-	for i := 1; i < len(incoming); i++ {
-		t1 := incoming[i-1].PlayedAt
-		i1 := incoming[i-1].Track.ID
-		t2 := incoming[i].PlayedAt
-		i2 := incoming[i].Track.ID
-		if t1.Truncate(30*time.Second).Equal(t2.Truncate(30*time.Second)) && i1 == i2 {
-			continue
-		}
-		outgoing = append(outgoing, incoming[i])
-	}
-
-	return outgoing
-}
-
 /*averageFloat - as the name suggest it averages vector of floats
  */
 func averageFloat(values []float64) float64 {
@@ -242,27 +279,41 @@ func searchType(a string) spotify.SearchType {
 	}
 }
 
-func cloudRecent(user string) {
-	url := os.Getenv("CLOUD_RECENT_FUNCTION")
+/*cloudRecent - asks CloudRecent function to fetch user's recently played
+tracks. Without the function (or with local database) the same is done
+in-process. Runs in background (see application.Go).
+*/
+func cloudRecent(ctx context.Context, user string) {
+	url := cfg.CloudRecentFunction
+	if (cfg.StoreBackend != "" && cfg.StoreBackend != "firestore") || url == "" {
+		if n, err := processRecentlyPlayed(ctx, user); err != nil {
+			log.Printf("cloudRecent: %s", err.Error())
+		} else {
+			log.Printf("cloudRecent: Processed %d tracks for %s", n, user)
+		}
+		return
+	}
 	client := &http.Client{}
 	token := getJWToken(url)
 	cloudRecent := fmt.Sprintf("%s?user=%s", url, user)
-	req, _ := http.NewRequest("GET", cloudRecent, nil)
+	req, _ := http.NewRequestWithContext(ctx, "GET", cloudRecent, nil)
 	req.Header.Add("Authorization", "Bearer "+token)
-	_, err := client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		log.Println(err.Error())
+		return
 	}
+	resp.Body.Close()
 }
 
 /*paginateHistory - is a helper func for paginating
 tracks listened to ie. history.
-It returns a firestore query for next/previous page
+It returns a query for next/previous page
 chunk size is set by global variable pageLimit (24)
 */
-func paginateHistory(page string, path string, c *gin.Context) *firestore.Query {
-	var q firestore.Query // we will be constructing firestore query here
-	if page != "0" {
+func paginateHistory(page string, c *gin.Context) historyQuery {
+	q := historyQuery{Limit: pageLimit} // we will be constructing query here
+	if page != "0" && page != "" {
 		lastPage, _ := c.Cookie("lastPage") // where we comming from
 		if ltString(lastPage, page) {       // check if we go back or forward
 			if lastDoc, err := c.Cookie("lastDoc"); err == nil { // lastDoc cookie stores when last track on pages had been playes
@@ -272,21 +323,17 @@ func paginateHistory(page string, path string, c *gin.Context) *firestore.Query
 					log.Println(err.Error())
 				}
 				// start query after last track on previous page (if going forward)
-				q = firestoreClient.Collection(path).OrderBy("played_at", firestore.Desc).
-					StartAfter(t).Limit(pageLimit)
+				q.Before = t
+				return q
 			}
-		} else {
-			// start query at offset (page size * # tracks on page)
-			p, _ := strconv.Atoi(page)
-			q = firestoreClient.Collection(path).OrderBy("played_at", firestore.Desc).
-				Offset(pageLimit * p).Limit(pageLimit)
 		}
-	} else {
-		// of this is zero page get mist recent tracks (which might have chaged in the meantime
-		// so make no assumptions)
-		q = firestoreClient.Collection(path).OrderBy("played_at", firestore.Desc).Limit(pageLimit)
+		// start query at offset (page size * # tracks on page)
+		p, _ := strconv.Atoi(page)
+		q.Offset = pageLimit * p
 	}
-	return &q
+	// of this is zero page get mist recent tracks (which might have chaged in the meantime
+	// so make no assumptions)
+	return q
 }
 
 /*navigation - return navigation object to page